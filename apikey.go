@@ -0,0 +1,195 @@
+package toolkit
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"hash/crc32"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	apiKeySecretLength   = 32
+	apiKeyChecksumLength = 6
+)
+
+var (
+	ErrAPIKeyInvalid  = errors.New("API key is invalid")
+	ErrAPIKeyNotFound = errors.New("API key not found")
+)
+
+// APIKey is what is stored for an API key: its hash, never the key itself.
+// A zero ExpiresAt means the key does not expire. The scope "*" grants every
+// scope.
+type APIKey struct {
+	ID        string    `json:"id"`
+	Hash      string    `json:"hash"`
+	Scopes    []string  `json:"scopes,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	ExpiresAt time.Time `json:"expires_at,omitempty"`
+	Revoked   bool      `json:"revoked,omitempty"`
+}
+
+func (k *APIKey) HasScope(scope string) bool {
+	for _, s := range k.Scopes {
+		if s == scope || s == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+// APIKeyStore finds keys by the hash of HashAPIKey. It returns
+// ErrAPIKeyNotFound for unknown hashes.
+type APIKeyStore interface {
+	LookupAPIKey(ctx context.Context, hash string) (*APIKey, error)
+}
+
+// APIKeyLookupFunc lets a function be used as an APIKeyStore.
+type APIKeyLookupFunc func(ctx context.Context, hash string) (*APIKey, error)
+
+func (f APIKeyLookupFunc) LookupAPIKey(ctx context.Context, hash string) (*APIKey, error) {
+	return f(ctx, hash)
+}
+
+// GenerateAPIKey returns a new key and the record to store for it. Keys look
+// like prefix_<32 random characters><6 character checksum>: the prefix makes
+// them easy to recognize, for instance by secret scanners, and the checksum
+// lets malformed keys be rejected without a lookup. The prefix must be made of
+// lowercase letters and digits. Records are identified by a ULID, whatever
+// UploadIDScheme says.
+func (t *Tools) GenerateAPIKey(prefix string, scopes ...string) (string, *APIKey, error) {
+	if !validAPIKeyPrefix(prefix) {
+		return "", nil, errors.New("API key prefix must be lowercase letters and digits")
+	}
+	secret, err := t.GenerateToken(apiKeySecretLength, AlphabetAlphanumeric)
+	if err != nil {
+		return "", nil, err
+	}
+	id, err := t.NewID(IDULID)
+	if err != nil {
+		return "", nil, err
+	}
+	body := prefix + "_" + secret
+	key := body + apiKeyChecksum(body)
+	return key, &APIKey{
+		ID:        id,
+		Hash:      HashAPIKey(key),
+		Scopes:    append([]string(nil), scopes...),
+		CreatedAt: time.Now().UTC(),
+	}, nil
+}
+
+// HashAPIKey returns the hex encoded SHA-256 of key. Keys are long random
+// strings, so a fast unsalted hash is enough to keep them out of storage.
+func HashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// ValidAPIKeyFormat reports whether key has the shape and checksum of a key
+// made by GenerateAPIKey.
+func ValidAPIKeyFormat(key string) bool {
+	prefix, rest, ok := strings.Cut(key, "_")
+	if !ok || !validAPIKeyPrefix(prefix) || len(rest) != apiKeySecretLength+apiKeyChecksumLength {
+		return false
+	}
+	if strings.Trim(rest, AlphabetAlphanumeric) != "" {
+		return false
+	}
+	body := key[:len(key)-apiKeyChecksumLength]
+	return apiKeyChecksum(body) == key[len(body):]
+}
+
+func validAPIKeyPrefix(prefix string) bool {
+	if prefix == "" || len(prefix) > 16 {
+		return false
+	}
+	for _, c := range prefix {
+		if !(c >= 'a' && c <= 'z' || c >= '0' && c <= '9') {
+			return false
+		}
+	}
+	return true
+}
+
+// apiKeyChecksum encodes the CRC-32 of body in base 62, which always fits in
+// six characters.
+func apiKeyChecksum(body string) string {
+	n := crc32.ChecksumIEEE([]byte(body))
+	out := make([]byte, apiKeyChecksumLength)
+	for i := len(out) - 1; i >= 0; i-- {
+		out[i] = AlphabetAlphanumeric[n%62]
+		n /= 62
+	}
+	return string(out)
+}
+
+type apiKeyContextKey struct{}
+
+func ContextWithAPIKey(ctx context.Context, key *APIKey) context.Context {
+	return context.WithValue(ctx, apiKeyContextKey{}, key)
+}
+
+func APIKeyFromContext(ctx context.Context) (*APIKey, bool) {
+	key, ok := ctx.Value(apiKeyContextKey{}).(*APIKey)
+	return key, ok
+}
+
+// RequireAPIKey only lets requests reach next if they carry a valid API key
+// granting all of scopes, in an X-API-Key header or as a bearer token. The
+// stored key is put in the request context. Missing, unknown, revoked and
+// expired keys get a 401 and keys lacking a scope a 403.
+func (t *Tools) RequireAPIKey(store APIKeyStore, scopes []string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get("X-API-Key")
+		if key == "" {
+			if scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " "); ok && strings.EqualFold(scheme, "Bearer") {
+				key = strings.TrimSpace(token)
+			}
+		}
+		if key == "" {
+			_ = t.ErrorJSON(w, errors.New("API key required"), http.StatusUnauthorized)
+			return
+		}
+		reject := func(err error, status int, reason string) {
+			t.log(r.Context(), slog.LevelInfo, "API key rejected",
+				slog.String("path", r.URL.Path),
+				slog.String("reason", reason))
+			_ = t.ErrorJSON(w, err, status)
+		}
+		if !ValidAPIKeyFormat(key) {
+			reject(ErrAPIKeyInvalid, http.StatusUnauthorized, "malformed")
+			return
+		}
+		record, err := store.LookupAPIKey(r.Context(), HashAPIKey(key))
+		switch {
+		case errors.Is(err, ErrAPIKeyNotFound) || (err == nil && record == nil):
+			reject(ErrAPIKeyInvalid, http.StatusUnauthorized, "unknown")
+			return
+		case err != nil:
+			t.log(r.Context(), slog.LevelError, "API key lookup failed", slog.String("error", err.Error()))
+			_ = t.ErrorJSON(w, errors.New(http.StatusText(http.StatusInternalServerError)), http.StatusInternalServerError)
+			return
+		case record.Revoked:
+			reject(ErrAPIKeyInvalid, http.StatusUnauthorized, "revoked")
+			return
+		case !record.ExpiresAt.IsZero() && time.Now().After(record.ExpiresAt):
+			reject(ErrAPIKeyInvalid, http.StatusUnauthorized, "expired")
+			return
+		}
+		for _, scope := range scopes {
+			if !record.HasScope(scope) {
+				reject(errors.New("API key lacks the required scope"), http.StatusForbidden, "scope "+scope)
+				return
+			}
+		}
+		ctx := ContextWithAPIKey(r.Context(), record)
+		ctx = ContextWithLogAttrs(ctx, slog.String("api_key_id", record.ID))
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
@@ -0,0 +1,132 @@
+package toolkit
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTools_GenerateAPIKey(t *testing.T) {
+	testTools := Tools{uploadIDScheme: IDUUIDv4}
+	key, record, err := testTools.GenerateAPIKey("tk", "files:read")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(key, "tk_") || len(key) != len("tk_")+38 {
+		t.Errorf("unexpected key %q", key)
+	}
+	if !ValidAPIKeyFormat(key) {
+		t.Errorf("generated key %q does not validate", key)
+	}
+	if record.Hash != HashAPIKey(key) || strings.Contains(record.Hash, key) {
+		t.Errorf("unexpected hash %q", record.Hash)
+	}
+	if _, err = ParseULID(record.ID); err != nil {
+		t.Errorf("expected a ULID record ID, got %q", record.ID)
+	}
+	if !record.HasScope("files:read") || record.HasScope("files:write") {
+		t.Errorf("unexpected scopes %v", record.Scopes)
+	}
+
+	// Changing any character must break the checksum.
+	typo := []byte(key)
+	if typo[10] == 'a' {
+		typo[10] = 'b'
+	} else {
+		typo[10] = 'a'
+	}
+	if ValidAPIKeyFormat(string(typo)) {
+		t.Error("key with a typo validated")
+	}
+	if _, _, err = testTools.GenerateAPIKey("Bad_Prefix"); err == nil {
+		t.Error("expected an error for an invalid prefix")
+	}
+}
+
+func TestTools_RequireAPIKey(t *testing.T) {
+	var testTools Tools
+	keys := make(map[string]*APIKey)
+	newKey := func(mutate func(*APIKey), scopes ...string) string {
+		key, record, err := testTools.GenerateAPIKey("tk", scopes...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if mutate != nil {
+			mutate(record)
+		}
+		keys[record.Hash] = record
+		return key
+	}
+	valid := newKey(nil, "files:read", "files:write")
+	admin := newKey(nil, "*")
+	readOnly := newKey(nil, "files:read")
+	revoked := newKey(func(k *APIKey) { k.Revoked = true }, "*")
+	expired := newKey(func(k *APIKey) { k.ExpiresAt = time.Now().Add(-time.Minute) }, "*")
+	unknown, _, _ := testTools.GenerateAPIKey("tk", "*")
+
+	store := APIKeyLookupFunc(func(ctx context.Context, hash string) (*APIKey, error) {
+		if k, ok := keys[hash]; ok {
+			return k, nil
+		}
+		return nil, ErrAPIKeyNotFound
+	})
+	handler := testTools.RequireAPIKey(store, []string{"files:write"}, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := APIKeyFromContext(r.Context()); !ok {
+			t.Error("API key missing from the context")
+		}
+	}))
+
+	var apiKeyTests = []struct {
+		name   string
+		header string
+		value  string
+		status int
+	}{
+		{name: "valid", header: "X-API-Key", value: valid, status: http.StatusOK},
+		{name: "bearer", header: "Authorization", value: "Bearer " + admin, status: http.StatusOK},
+		{name: "missing", status: http.StatusUnauthorized},
+		{name: "malformed", header: "X-API-Key", value: "tk_nope", status: http.StatusUnauthorized},
+		{name: "unknown", header: "X-API-Key", value: unknown, status: http.StatusUnauthorized},
+		{name: "revoked", header: "X-API-Key", value: revoked, status: http.StatusUnauthorized},
+		{name: "expired", header: "X-API-Key", value: expired, status: http.StatusUnauthorized},
+		{name: "missing scope", header: "X-API-Key", value: readOnly, status: http.StatusForbidden},
+	}
+	for _, e := range apiKeyTests {
+		req := httptest.NewRequest("POST", "/files", nil)
+		if e.header != "" {
+			req.Header.Set(e.header, e.value)
+		}
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		if rr.Code != e.status {
+			t.Errorf("%s: expected status %d, got %d", e.name, e.status, rr.Code)
+		}
+		if e.status != http.StatusOK {
+			var payload JSONResponse
+			if err := json.NewDecoder(rr.Body).Decode(&payload); err != nil || !payload.Error {
+				t.Errorf("%s: expected an error body, got %+v", e.name, payload)
+			}
+		}
+	}
+}
+
+func TestTools_RequireAPIKeyStoreError(t *testing.T) {
+	var testTools Tools
+	key, _, _ := testTools.GenerateAPIKey("tk")
+	store := APIKeyLookupFunc(func(ctx context.Context, hash string) (*APIKey, error) {
+		return nil, errors.New("database is down")
+	})
+	handler := testTools.RequireAPIKey(store, nil, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("X-API-Key", key)
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	if rr.Code != http.StatusInternalServerError || strings.Contains(rr.Body.String(), "database") {
+		t.Errorf("unexpected response %d %s", rr.Code, rr.Body.String())
+	}
+}
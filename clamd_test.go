@@ -0,0 +1,108 @@
+package toolkit
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func startFakeClamd(t *testing.T) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Skip("Cannot listen on loopback:", err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go serveFakeClamd(conn)
+		}
+	}()
+	return l.Addr().String()
+}
+
+func serveFakeClamd(conn net.Conn) {
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	command, err := reader.ReadString(0)
+	if err != nil {
+		return
+	}
+	switch command {
+	case "zPING\x00":
+		conn.Write([]byte("PONG\x00"))
+	case "zINSTREAM\x00":
+		var data bytes.Buffer
+		for {
+			var size uint32
+			if err := binary.Read(reader, binary.BigEndian, &size); err != nil {
+				return
+			}
+			if size == 0 {
+				break
+			}
+			if _, err := io.CopyN(&data, reader, int64(size)); err != nil {
+				return
+			}
+		}
+		if strings.Contains(data.String(), "EICAR") {
+			conn.Write([]byte("stream: Eicar-Test-Signature FOUND\x00"))
+		} else {
+			conn.Write([]byte("stream: OK\x00"))
+		}
+	}
+}
+
+var clamdTests = []struct {
+	name      string
+	content   string
+	infected  bool
+	signature string
+}{
+	{
+		name:    "clean file",
+		content: "nothing to see here",
+	},
+	{
+		name:      "infected file",
+		content:   "X5O!P%@AP[4\\PZX54(P^)7CC)7}$EICAR-STANDARD-ANTIVIRUS-TEST-FILE!$H+H*",
+		infected:  true,
+		signature: "Eicar-Test-Signature",
+	},
+}
+
+func TestClamdScanner_Scan(t *testing.T) {
+	scanner := ClamdScanner{Network: "tcp", Address: startFakeClamd(t), ChunkSize: 8}
+	if err := scanner.Ping(context.Background()); err != nil {
+		t.Fatal("Ping failed", err)
+	}
+
+	for _, e := range clamdTests {
+		path := filepath.Join(t.TempDir(), "file")
+		if err := os.WriteFile(path, []byte(e.content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		result, err := scanner.Scan(context.Background(), f)
+		f.Close()
+		if err != nil {
+			t.Errorf("%s - Error not expected, but got one : %s", e.name, err)
+		}
+		if result.Infected != e.infected || result.Signature != e.signature {
+			t.Errorf("%s - Unexpected scan result: %+v", e.name, result)
+		}
+	}
+}
@@ -0,0 +1,215 @@
+package toolkit
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const maxCookieSize = 4096
+
+var ErrCookieInvalid = errors.New("Cookie is invalid")
+
+// SecureCookie reads and writes cookies holding JSON encoded values that are
+// signed with HMAC-SHA256, or encrypted with AES-256-GCM when Encrypt is set,
+// so that clients can neither forge nor, when encrypted, read them. Keys work
+// as for URLSigner: cookies are written with CurrentKey and cookies written
+// with any other key still in Keys can still be read. Keys may be of any
+// length but should have at least 32 random bytes.
+//
+// Cookies are HttpOnly, Secure unless Insecure is set, and SameSite=Lax unless
+// SameSite says otherwise. With a MaxAge, cookies expire in the browser and
+// older values are rejected when read.
+type SecureCookie struct {
+	Keys       map[string][]byte
+	CurrentKey string
+	Encrypt    bool
+	MaxAge     time.Duration
+	Path       string
+	Domain     string
+	Insecure   bool
+	SameSite   http.SameSite
+
+	now func() time.Time
+}
+
+func (c *SecureCookie) clock() time.Time {
+	if c.now != nil {
+		return c.now()
+	}
+	return time.Now()
+}
+
+// Encode returns the cookie value for value. The name of the cookie is
+// authenticated too, so a value cannot be moved to another cookie.
+func (c *SecureCookie) Encode(name string, value interface{}) (string, error) {
+	key, ok := c.Keys[c.CurrentKey]
+	if !ok {
+		return "", errors.New("Current cookie key is not in the key set")
+	}
+	data, err := json.Marshal(value)
+	if err != nil {
+		return "", err
+	}
+	kid := base64.RawURLEncoding.EncodeToString([]byte(c.CurrentKey))
+	ts := strconv.FormatInt(c.clock().Unix(), 10)
+	header := kid + "." + ts
+
+	var encoded string
+	if c.Encrypt {
+		aead, err := cookieAEAD(key)
+		if err != nil {
+			return "", err
+		}
+		nonce := make([]byte, aead.NonceSize())
+		if _, err = rand.Read(nonce); err != nil {
+			return "", err
+		}
+		box := aead.Seal(nonce, nonce, data, []byte(name+"."+header))
+		encoded = header + "." + base64.RawURLEncoding.EncodeToString(box)
+	} else {
+		payload := header + "." + base64.RawURLEncoding.EncodeToString(data)
+		encoded = payload + "." + base64.RawURLEncoding.EncodeToString(cookieMAC(key, name+"."+payload))
+	}
+	if len(name)+len(encoded) > maxCookieSize {
+		return "", errors.New("Cookie value is too large")
+	}
+	return encoded, nil
+}
+
+// Decode verifies value and unmarshals it into dst. It returns
+// ErrCookieInvalid for tampered, unknown key or expired values.
+func (c *SecureCookie) Decode(name, value string, dst interface{}) error {
+	parts := strings.Split(value, ".")
+	if (c.Encrypt && len(parts) != 3) || (!c.Encrypt && len(parts) != 4) {
+		return ErrCookieInvalid
+	}
+	kid, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return ErrCookieInvalid
+	}
+	key, ok := c.Keys[string(kid)]
+	if !ok {
+		return ErrCookieInvalid
+	}
+	ts, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return ErrCookieInvalid
+	}
+	header := parts[0] + "." + parts[1]
+
+	var data []byte
+	if c.Encrypt {
+		aead, err := cookieAEAD(key)
+		if err != nil {
+			return err
+		}
+		box, err := base64.RawURLEncoding.DecodeString(parts[2])
+		if err != nil || len(box) < aead.NonceSize() {
+			return ErrCookieInvalid
+		}
+		nonce, ciphertext := box[:aead.NonceSize()], box[aead.NonceSize():]
+		if data, err = aead.Open(nil, nonce, ciphertext, []byte(name+"."+header)); err != nil {
+			return ErrCookieInvalid
+		}
+	} else {
+		payload := header + "." + parts[2]
+		signature, err := base64.RawURLEncoding.DecodeString(parts[3])
+		if err != nil || !hmac.Equal(signature, cookieMAC(key, name+"."+payload)) {
+			return ErrCookieInvalid
+		}
+		if data, err = base64.RawURLEncoding.DecodeString(parts[2]); err != nil {
+			return ErrCookieInvalid
+		}
+	}
+	if c.MaxAge > 0 && c.clock().Sub(time.Unix(ts, 0)) > c.MaxAge {
+		return ErrCookieInvalid
+	}
+	if err = json.Unmarshal(data, dst); err != nil {
+		return ErrCookieInvalid
+	}
+	return nil
+}
+
+// Set writes value to the cookie name.
+func (c *SecureCookie) Set(w http.ResponseWriter, name string, value interface{}) error {
+	encoded, err := c.Encode(name, value)
+	if err != nil {
+		return err
+	}
+	cookie := c.cookie(name, encoded)
+	if c.MaxAge > 0 {
+		cookie.MaxAge = int(c.MaxAge.Seconds())
+		cookie.Expires = c.clock().Add(c.MaxAge)
+	}
+	http.SetCookie(w, cookie)
+	return nil
+}
+
+// Get reads the cookie name into dst. It returns http.ErrNoCookie when the
+// request has no such cookie.
+func (c *SecureCookie) Get(r *http.Request, name string, dst interface{}) error {
+	cookie, err := r.Cookie(name)
+	if err != nil {
+		return err
+	}
+	return c.Decode(name, cookie.Value, dst)
+}
+
+func (c *SecureCookie) Delete(w http.ResponseWriter, name string) {
+	cookie := c.cookie(name, "")
+	cookie.MaxAge = -1
+	cookie.Expires = time.Unix(0, 0)
+	http.SetCookie(w, cookie)
+}
+
+func (c *SecureCookie) cookie(name, value string) *http.Cookie {
+	path := c.Path
+	if path == "" {
+		path = "/"
+	}
+	sameSite := c.SameSite
+	if sameSite == 0 {
+		sameSite = http.SameSiteLaxMode
+	}
+	return &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     path,
+		Domain:   c.Domain,
+		HttpOnly: true,
+		Secure:   !c.Insecure,
+		SameSite: sameSite,
+	}
+}
+
+// Separate keys are derived for signing and encryption, so the same secret
+// can safely serve both.
+func cookieMAC(key []byte, message string) []byte {
+	mac := hmac.New(sha256.New, deriveCookieKey(key, "sign"))
+	mac.Write([]byte(message))
+	return mac.Sum(nil)
+}
+
+func cookieAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(deriveCookieKey(key, "encrypt"))
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func deriveCookieKey(key []byte, purpose string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte("toolkit cookie " + purpose))
+	return mac.Sum(nil)
+}
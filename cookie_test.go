@@ -0,0 +1,108 @@
+package toolkit
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+type cookieValue struct {
+	UserID int    `json:"user_id"`
+	Role   string `json:"role"`
+}
+
+func TestSecureCookie_RoundTrip(t *testing.T) {
+	for _, encrypt := range []bool{false, true} {
+		c := &SecureCookie{Keys: map[string][]byte{"k1": []byte("secret")}, CurrentKey: "k1", Encrypt: encrypt}
+		rr := httptest.NewRecorder()
+		if err := c.Set(rr, "auth", cookieValue{UserID: 7, Role: "admin"}); err != nil {
+			t.Fatal(err)
+		}
+		cookies := rr.Result().Cookies()
+		if len(cookies) != 1 {
+			t.Fatalf("expected one cookie, got %d", len(cookies))
+		}
+		cookie := cookies[0]
+		if !cookie.HttpOnly || !cookie.Secure || cookie.SameSite != http.SameSiteLaxMode || cookie.Path != "/" {
+			t.Errorf("encrypt %v: unexpected cookie attributes %+v", encrypt, cookie)
+		}
+		if strings.Contains(cookie.Value, "admin") {
+			t.Errorf("encrypt %v: value leaked the role", encrypt)
+		}
+
+		req := httptest.NewRequest("GET", "/", nil)
+		req.AddCookie(cookie)
+		var got cookieValue
+		if err := c.Get(req, "auth", &got); err != nil || got.UserID != 7 || got.Role != "admin" {
+			t.Errorf("encrypt %v: unexpected value %+v, %v", encrypt, got, err)
+		}
+	}
+}
+
+func TestSecureCookie_Decode(t *testing.T) {
+	keys := map[string][]byte{"old": []byte("old secret"), "new": []byte("new secret")}
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	old := &SecureCookie{Keys: keys, CurrentKey: "old", now: func() time.Time { return now }}
+	encoded, err := old.Encode("auth", "value")
+	if err != nil {
+		t.Fatal(err)
+	}
+	encrypted, err := (&SecureCookie{Keys: keys, CurrentKey: "old", Encrypt: true}).Encode("auth", "value")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tampered := []byte(encoded)
+	tampered[len(tampered)-1] ^= 1
+
+	var cookieTests = []struct {
+		name   string
+		cookie *SecureCookie
+		cname  string
+		value  string
+		valid  bool
+	}{
+		{name: "valid", cookie: old, cname: "auth", value: encoded, valid: true},
+		{name: "rotated key", cookie: &SecureCookie{Keys: keys, CurrentKey: "new"}, cname: "auth", value: encoded, valid: true},
+		{name: "removed key", cookie: &SecureCookie{Keys: map[string][]byte{"new": keys["new"]}, CurrentKey: "new"}, cname: "auth", value: encoded},
+		{name: "tampered", cookie: old, cname: "auth", value: string(tampered)},
+		{name: "other cookie name", cookie: old, cname: "prefs", value: encoded},
+		{name: "expired", cookie: &SecureCookie{Keys: keys, CurrentKey: "old", MaxAge: time.Hour, now: func() time.Time { return now.Add(2 * time.Hour) }}, cname: "auth", value: encoded},
+		{name: "within max age", cookie: &SecureCookie{Keys: keys, CurrentKey: "old", MaxAge: time.Hour, now: func() time.Time { return now.Add(time.Minute) }}, cname: "auth", value: encoded, valid: true},
+		{name: "encrypted", cookie: &SecureCookie{Keys: keys, CurrentKey: "new", Encrypt: true}, cname: "auth", value: encrypted, valid: true},
+		{name: "encrypted read as signed", cookie: old, cname: "auth", value: encrypted},
+		{name: "garbage", cookie: old, cname: "auth", value: "a.b.c.d"},
+	}
+	for _, e := range cookieTests {
+		var got string
+		err := e.cookie.Decode(e.cname, e.value, &got)
+		if e.valid && (err != nil || got != "value") {
+			t.Errorf("%s: expected the value to decode, got %q, %v", e.name, got, err)
+		}
+		if !e.valid && err != ErrCookieInvalid {
+			t.Errorf("%s: expected ErrCookieInvalid, got %v", e.name, err)
+		}
+	}
+}
+
+func TestSecureCookie_Errors(t *testing.T) {
+	c := &SecureCookie{Keys: map[string][]byte{"k1": []byte("secret")}, CurrentKey: "k2"}
+	if _, err := c.Encode("auth", "value"); err == nil {
+		t.Error("expected an error for a missing current key")
+	}
+	c.CurrentKey = "k1"
+	if _, err := c.Encode("auth", strings.Repeat("a", maxCookieSize)); err == nil {
+		t.Error("expected an error for an oversized value")
+	}
+	var got string
+	if err := c.Get(httptest.NewRequest("GET", "/", nil), "auth", &got); err != http.ErrNoCookie {
+		t.Errorf("expected http.ErrNoCookie, got %v", err)
+	}
+
+	rr := httptest.NewRecorder()
+	c.Delete(rr, "auth")
+	if cookies := rr.Result().Cookies(); len(cookies) != 1 || cookies[0].MaxAge != -1 {
+		t.Errorf("expected an expiring cookie, got %+v", cookies)
+	}
+}
@@ -0,0 +1,184 @@
+package toolkit
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CORSOptions configures the CORS middleware. AllowedOrigins holds exact
+// origins such as "https://example.com", patterns with a wildcard subdomain
+// such as "https://*.example.com", or "*" for any origin. AllowedMethods
+// defaults to GET, HEAD and POST, and AllowedHeaders to Content-Type; "*" in
+// AllowedHeaders allows any header. When RejectDisallowed is set, requests from
+// other origins get a 403 through ErrorJSON instead of a response without CORS
+// headers.
+//
+// AllowCredentials only applies to the origins listed explicitly or matched by
+// a wildcard subdomain: origins allowed through "*" are not allowed
+// credentials, as that would let any site make authenticated requests. Set
+// AllowAnyOriginWithCredentials as well to allow them anyway, for instance for
+// a public API whose credentials are never cookies.
+type CORSOptions struct {
+	AllowedOrigins                []string
+	AllowedMethods                []string
+	AllowedHeaders                []string
+	ExposedHeaders                []string
+	AllowCredentials              bool
+	AllowAnyOriginWithCredentials bool
+	MaxAge                        time.Duration
+	RejectDisallowed              bool
+}
+
+var (
+	errOriginNotAllowed  = errors.New("Origin is not allowed")
+	errMethodNotAllowed  = errors.New("Method is not allowed")
+	errHeadersNotAllowed = errors.New("Request headers are not allowed")
+)
+
+// CORS answers preflight requests itself and adds the CORS headers to the
+// other responses of next.
+func (t *Tools) CORS(opts CORSOptions, next http.Handler) http.Handler {
+	methods := opts.AllowedMethods
+	if len(methods) == 0 {
+		methods = []string{http.MethodGet, http.MethodHead, http.MethodPost}
+	}
+	headers := opts.AllowedHeaders
+	if len(headers) == 0 {
+		headers = []string{"Content-Type"}
+	}
+	anyHeader := false
+	for _, h := range headers {
+		anyHeader = anyHeader || h == "*"
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		preflight := r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != ""
+		h := w.Header()
+		h.Add("Vary", "Origin")
+		if preflight {
+			h.Add("Vary", "Access-Control-Request-Method")
+			h.Add("Vary", "Access-Control-Request-Headers")
+		}
+
+		origin := r.Header.Get("Origin")
+		if origin == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		reject := func(err error) {
+			if opts.RejectDisallowed {
+				_ = t.ErrorJSON(w, err, http.StatusForbidden)
+			} else if preflight {
+				w.WriteHeader(http.StatusNoContent)
+			} else {
+				next.ServeHTTP(w, r)
+			}
+		}
+		allowOrigin, credentials, ok := opts.allowOrigin(origin)
+		if !ok {
+			reject(errOriginNotAllowed)
+			return
+		}
+
+		if preflight {
+			method := r.Header.Get("Access-Control-Request-Method")
+			if !containsFold(methods, method) {
+				reject(errMethodNotAllowed)
+				return
+			}
+			requested := splitHeaderList(r.Header.Get("Access-Control-Request-Headers"))
+			if !anyHeader {
+				for _, name := range requested {
+					if !containsFold(headers, name) {
+						reject(errHeadersNotAllowed)
+						return
+					}
+				}
+			}
+			h.Set("Access-Control-Allow-Origin", allowOrigin)
+			if credentials {
+				h.Set("Access-Control-Allow-Credentials", "true")
+			}
+			h.Set("Access-Control-Allow-Methods", strings.Join(methods, ", "))
+			if len(requested) > 0 {
+				h.Set("Access-Control-Allow-Headers", strings.Join(requested, ", "))
+			}
+			if opts.MaxAge > 0 {
+				h.Set("Access-Control-Max-Age", strconv.Itoa(int(opts.MaxAge.Seconds())))
+			}
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		h.Set("Access-Control-Allow-Origin", allowOrigin)
+		if credentials {
+			h.Set("Access-Control-Allow-Credentials", "true")
+		}
+		if len(opts.ExposedHeaders) > 0 {
+			h.Set("Access-Control-Expose-Headers", strings.Join(opts.ExposedHeaders, ", "))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// allowOrigin returns the value of Access-Control-Allow-Origin for origin, and
+// whether credentials are allowed. Origins listed explicitly take precedence
+// over "*". Browsers refuse "*" on requests with credentials, so the origin is
+// echoed instead when AllowAnyOriginWithCredentials is set.
+func (opts CORSOptions) allowOrigin(origin string) (string, bool, bool) {
+	origin = strings.ToLower(origin)
+	anyOrigin := false
+	for _, allowed := range opts.AllowedOrigins {
+		allowed = strings.ToLower(allowed)
+		switch {
+		case allowed == "*":
+			anyOrigin = true
+		case allowed == origin, matchWildcardOrigin(allowed, origin):
+			return origin, opts.AllowCredentials, true
+		}
+	}
+	switch {
+	case anyOrigin && opts.AllowCredentials && opts.AllowAnyOriginWithCredentials:
+		return origin, true, true
+	case anyOrigin:
+		return "*", false, true
+	}
+	return "", false, false
+}
+
+// matchWildcardOrigin matches origin against a pattern such as
+// "https://*.example.com", where the wildcard stands for one or more
+// subdomain labels.
+func matchWildcardOrigin(pattern, origin string) bool {
+	prefix, suffix, ok := strings.Cut(pattern, "*")
+	if !ok || !strings.HasPrefix(origin, prefix) || !strings.HasSuffix(origin, suffix) {
+		return false
+	}
+	if len(origin) <= len(prefix)+len(suffix) {
+		return false
+	}
+	sub := origin[len(prefix) : len(origin)-len(suffix)]
+	return !strings.ContainsAny(sub, "/:@") && !strings.HasPrefix(sub, ".") && !strings.HasSuffix(sub, ".")
+}
+
+func containsFold(list []string, s string) bool {
+	for _, item := range list {
+		if strings.EqualFold(item, s) {
+			return true
+		}
+	}
+	return false
+}
+
+func splitHeaderList(value string) []string {
+	var names []string
+	for _, name := range strings.Split(value, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
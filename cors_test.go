@@ -0,0 +1,197 @@
+package toolkit
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+var corsTests = []struct {
+	name          string
+	opts          CORSOptions
+	method        string
+	origin        string
+	requestMethod string
+	requestHdrs   string
+	status        int
+	allowOrigin   string
+	allowHeaders  string
+	credentials   bool
+	reachedNext   bool
+}{
+	{
+		name:        "no origin",
+		opts:        CORSOptions{AllowedOrigins: []string{"https://example.com"}},
+		method:      "GET",
+		status:      http.StatusOK,
+		reachedNext: true,
+	},
+	{
+		name:        "exact origin",
+		opts:        CORSOptions{AllowedOrigins: []string{"https://example.com"}},
+		method:      "GET",
+		origin:      "https://example.com",
+		status:      http.StatusOK,
+		allowOrigin: "https://example.com",
+		reachedNext: true,
+	},
+	{
+		name:        "wildcard subdomain",
+		opts:        CORSOptions{AllowedOrigins: []string{"https://*.example.com"}},
+		method:      "GET",
+		origin:      "https://api.eu.example.com",
+		status:      http.StatusOK,
+		allowOrigin: "https://api.eu.example.com",
+		reachedNext: true,
+	},
+	{
+		name:        "wildcard does not match apex or other domains",
+		opts:        CORSOptions{AllowedOrigins: []string{"https://*.example.com"}},
+		method:      "GET",
+		origin:      "https://evil.com/.example.com",
+		status:      http.StatusOK,
+		reachedNext: true,
+	},
+	{
+		name:        "any origin",
+		opts:        CORSOptions{AllowedOrigins: []string{"*"}},
+		method:      "GET",
+		origin:      "https://example.org",
+		status:      http.StatusOK,
+		allowOrigin: "*",
+		reachedNext: true,
+	},
+	{
+		name:        "any origin is not allowed credentials",
+		opts:        CORSOptions{AllowedOrigins: []string{"*"}, AllowCredentials: true},
+		method:      "GET",
+		origin:      "https://example.org",
+		status:      http.StatusOK,
+		allowOrigin: "*",
+		reachedNext: true,
+	},
+	{
+		name:        "listed origin keeps credentials next to any origin",
+		opts:        CORSOptions{AllowedOrigins: []string{"*", "https://example.com"}, AllowCredentials: true},
+		method:      "GET",
+		origin:      "https://example.com",
+		status:      http.StatusOK,
+		allowOrigin: "https://example.com",
+		credentials: true,
+		reachedNext: true,
+	},
+	{
+		name:        "any origin with credentials when opted in",
+		opts:        CORSOptions{AllowedOrigins: []string{"*"}, AllowCredentials: true, AllowAnyOriginWithCredentials: true},
+		method:      "GET",
+		origin:      "https://example.org",
+		status:      http.StatusOK,
+		allowOrigin: "https://example.org",
+		credentials: true,
+		reachedNext: true,
+	},
+	{
+		name:   "rejected origin",
+		opts:   CORSOptions{AllowedOrigins: []string{"https://example.com"}, RejectDisallowed: true},
+		method: "GET",
+		origin: "https://example.org",
+		status: http.StatusForbidden,
+	},
+	{
+		name:          "preflight",
+		opts:          CORSOptions{AllowedOrigins: []string{"https://example.com"}, AllowedMethods: []string{"PUT"}, AllowedHeaders: []string{"Content-Type", "X-Request-ID"}, MaxAge: time.Hour},
+		method:        "OPTIONS",
+		origin:        "https://example.com",
+		requestMethod: "PUT",
+		requestHdrs:   "content-type, x-request-id",
+		status:        http.StatusNoContent,
+		allowOrigin:   "https://example.com",
+		allowHeaders:  "content-type, x-request-id",
+	},
+	{
+		name:          "preflight with a header not allowed",
+		opts:          CORSOptions{AllowedOrigins: []string{"https://example.com"}},
+		method:        "OPTIONS",
+		origin:        "https://example.com",
+		requestMethod: "POST",
+		requestHdrs:   "Authorization",
+		status:        http.StatusNoContent,
+	},
+	{
+		name:          "preflight with a method not allowed",
+		opts:          CORSOptions{AllowedOrigins: []string{"https://example.com"}, RejectDisallowed: true},
+		method:        "OPTIONS",
+		origin:        "https://example.com",
+		requestMethod: "DELETE",
+		status:        http.StatusForbidden,
+	},
+}
+
+func TestTools_CORS(t *testing.T) {
+	var testTools Tools
+	for _, e := range corsTests {
+		reached := false
+		handler := testTools.CORS(e.opts, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			reached = true
+		}))
+		req := httptest.NewRequest(e.method, "/", nil)
+		if e.origin != "" {
+			req.Header.Set("Origin", e.origin)
+		}
+		if e.requestMethod != "" {
+			req.Header.Set("Access-Control-Request-Method", e.requestMethod)
+		}
+		if e.requestHdrs != "" {
+			req.Header.Set("Access-Control-Request-Headers", e.requestHdrs)
+		}
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.status {
+			t.Errorf("%s: expected status %d, got %d", e.name, e.status, rr.Code)
+		}
+		if got := rr.Header().Get("Access-Control-Allow-Origin"); got != e.allowOrigin {
+			t.Errorf("%s: expected allowed origin %q, got %q", e.name, e.allowOrigin, got)
+		}
+		if got := rr.Header().Get("Access-Control-Allow-Credentials") == "true"; got != e.credentials {
+			t.Errorf("%s: expected credentials %v, got %v", e.name, e.credentials, got)
+		}
+		if got := rr.Header().Get("Access-Control-Allow-Headers"); got != e.allowHeaders {
+			t.Errorf("%s: expected allowed headers %q, got %q", e.name, e.allowHeaders, got)
+		}
+		if reached != e.reachedNext {
+			t.Errorf("%s: expected next reached %v, got %v", e.name, e.reachedNext, reached)
+		}
+		if !strings.Contains(strings.Join(rr.Header().Values("Vary"), ","), "Origin") {
+			t.Errorf("%s: Vary: Origin missing", e.name)
+		}
+	}
+}
+
+func TestTools_CORSActualResponseHeaders(t *testing.T) {
+	var testTools Tools
+	opts := CORSOptions{
+		AllowedOrigins:   []string{"https://example.com"},
+		AllowCredentials: true,
+		ExposedHeaders:   []string{"X-Request-ID", "RateLimit-Remaining"},
+	}
+	handler := testTools.CORS(opts, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = testTools.WriteJSON(w, http.StatusOK, struct{}{})
+	}))
+	req := httptest.NewRequest("POST", "/", nil)
+	req.Header.Set("Origin", "https://example.com")
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	if rr.Header().Get("Access-Control-Allow-Credentials") != "true" {
+		t.Error("credentials header missing")
+	}
+	if rr.Header().Get("Access-Control-Expose-Headers") != "X-Request-ID, RateLimit-Remaining" {
+		t.Errorf("unexpected exposed headers %q", rr.Header().Get("Access-Control-Expose-Headers"))
+	}
+	if rr.Header().Get("Content-Type") != "application/json" {
+		t.Errorf("unexpected content type %q", rr.Header().Get("Content-Type"))
+	}
+}
@@ -0,0 +1,260 @@
+package toolkit
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"io"
+	"log/slog"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+const (
+	csrfTokenLength = 32
+	// maxCSRFPeek bounds how much of a multipart body is buffered while
+	// looking for the token field.
+	maxCSRFPeek = 64 * 1024
+)
+
+var (
+	ErrCSRFToken  = errors.New("CSRF token is missing or invalid")
+	ErrCSRFOrigin = errors.New("Request origin is not allowed")
+)
+
+// CSRFStore keeps the synchronizer token of the session a request belongs
+// to. Get returns "" when the session has no token yet.
+type CSRFStore interface {
+	GetCSRFToken(r *http.Request) (string, error)
+	SetCSRFToken(w http.ResponseWriter, r *http.Request, token string) error
+}
+
+// CSRFOptions configures the CSRF middleware. With a Store, tokens are
+// synchronizer tokens kept in the session; without one, they are kept in a
+// cookie that must be echoed in the request (double submit), and signed with
+// Key when it is set so that a cookie planted by a sibling subdomain is
+// rejected.
+//
+// The token is looked for in the HeaderName header, then in the FieldName form
+// field. In multipart bodies the field must come before the files, so that it
+// can be read without parsing the whole body.
+//
+// Unsafe requests with an Origin, or failing that a Referer, from a host other
+// than the request's are rejected unless the origin is in TrustedOrigins.
+// Requests for which Exempt returns true are not checked at all;
+// CSRFExemptTokenAuth exempts requests authenticated by a bearer token or an
+// API key, which browsers never send on their own.
+type CSRFOptions struct {
+	Store          CSRFStore
+	Key            []byte
+	CookieName     string
+	HeaderName     string
+	FieldName      string
+	Secure         bool
+	TrustedOrigins []string
+	Exempt         func(r *http.Request) bool
+}
+
+func (o CSRFOptions) cookieName() string {
+	if o.CookieName != "" {
+		return o.CookieName
+	}
+	return "csrf_token"
+}
+
+func (o CSRFOptions) headerName() string {
+	if o.HeaderName != "" {
+		return o.HeaderName
+	}
+	return "X-CSRF-Token"
+}
+
+func (o CSRFOptions) fieldName() string {
+	if o.FieldName != "" {
+		return o.FieldName
+	}
+	return "csrf_token"
+}
+
+// CSRFExemptTokenAuth reports whether r carries a bearer token or an API key.
+func CSRFExemptTokenAuth(r *http.Request) bool {
+	scheme, _, _ := strings.Cut(r.Header.Get("Authorization"), " ")
+	return strings.EqualFold(scheme, "Bearer") || r.Header.Get("X-API-Key") != ""
+}
+
+type csrfTokenKey struct{}
+
+// CSRFToken returns the token to embed in the forms of a page, as set by the
+// CSRF middleware.
+func CSRFToken(r *http.Request) string {
+	token, _ := r.Context().Value(csrfTokenKey{}).(string)
+	return token
+}
+
+// CSRF protects the unsafe requests of next against cross-site request
+// forgery, and makes the token available to it through CSRFToken.
+func (t *Tools) CSRF(opts CSRFOptions, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if opts.Exempt != nil && opts.Exempt(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		expected, err := t.csrfToken(w, r, opts)
+		if err != nil {
+			t.log(r.Context(), slog.LevelError, "CSRF token unavailable", slog.String("error", err.Error()))
+			_ = t.ErrorJSON(w, errors.New(http.StatusText(http.StatusInternalServerError)), http.StatusInternalServerError)
+			return
+		}
+		r = r.WithContext(context.WithValue(r.Context(), csrfTokenKey{}, expected))
+
+		switch r.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+			next.ServeHTTP(w, r)
+			return
+		}
+		reject := func(err error) {
+			t.log(r.Context(), slog.LevelWarn, "CSRF check failed",
+				slog.String("method", r.Method),
+				slog.String("path", r.URL.Path),
+				slog.String("reason", err.Error()))
+			_ = t.ErrorJSON(w, err, http.StatusForbidden)
+		}
+		if !opts.originAllowed(r) {
+			reject(ErrCSRFOrigin)
+			return
+		}
+		submitted := r.Header.Get(opts.headerName())
+		if submitted == "" {
+			submitted = csrfFormToken(r, opts.fieldName())
+		}
+		if submitted == "" || subtle.ConstantTimeCompare([]byte(submitted), []byte(expected)) != 1 {
+			reject(ErrCSRFToken)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// csrfToken returns the token of the session or the cookie, creating it if
+// there is none yet.
+func (t *Tools) csrfToken(w http.ResponseWriter, r *http.Request, opts CSRFOptions) (string, error) {
+	if opts.Store != nil {
+		token, err := opts.Store.GetCSRFToken(r)
+		if err != nil || token != "" {
+			return token, err
+		}
+		if token, err = t.GenerateToken(csrfTokenLength, AlphabetURLSafe); err != nil {
+			return "", err
+		}
+		return token, opts.Store.SetCSRFToken(w, r, token)
+	}
+
+	if c, err := r.Cookie(opts.cookieName()); err == nil && opts.validCookieToken(c.Value) {
+		return c.Value, nil
+	}
+	token, err := t.GenerateToken(csrfTokenLength, AlphabetURLSafe)
+	if err != nil {
+		return "", err
+	}
+	if len(opts.Key) > 0 {
+		token += "." + opts.sign(token)
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     opts.cookieName(),
+		Value:    token,
+		Path:     "/",
+		Secure:   opts.Secure || r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+	return token, nil
+}
+
+func (o CSRFOptions) sign(token string) string {
+	mac := hmac.New(sha256.New, o.Key)
+	mac.Write([]byte(token))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func (o CSRFOptions) validCookieToken(value string) bool {
+	if len(o.Key) == 0 {
+		return len(value) == csrfTokenLength
+	}
+	token, signature, ok := strings.Cut(value, ".")
+	return ok && len(token) == csrfTokenLength && hmac.Equal([]byte(signature), []byte(o.sign(token)))
+}
+
+func (o CSRFOptions) originAllowed(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		referer, err := url.Parse(r.Header.Get("Referer"))
+		if err != nil {
+			return false
+		}
+		if referer.Host == "" {
+			// Without Origin or Referer only the token protects the request.
+			return true
+		}
+		origin = referer.Scheme + "://" + referer.Host
+	}
+	u, err := url.Parse(origin)
+	if err != nil || u.Host == "" {
+		return false
+	}
+	if strings.EqualFold(u.Host, r.Host) && (r.TLS == nil || u.Scheme == "https") {
+		return true
+	}
+	for _, trusted := range o.TrustedOrigins {
+		if strings.EqualFold(strings.TrimSuffix(trusted, "/"), u.Scheme+"://"+u.Host) {
+			return true
+		}
+	}
+	return false
+}
+
+// csrfFormToken reads field from a urlencoded form, or from the parts of a
+// multipart body preceding the first file. The multipart body is put back
+// together so that UploadFiles can still parse it.
+func csrfFormToken(r *http.Request, field string) string {
+	mediaType, params, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil {
+		return ""
+	}
+	switch mediaType {
+	case "application/x-www-form-urlencoded":
+		return r.PostFormValue(field)
+	case "multipart/form-data":
+	default:
+		return ""
+	}
+	if r.Body == nil || params["boundary"] == "" {
+		return ""
+	}
+
+	body := r.Body
+	var peeked bytes.Buffer
+	mr := multipart.NewReader(io.TeeReader(io.LimitReader(body, maxCSRFPeek), &peeked), params["boundary"])
+	token := ""
+	for {
+		part, err := mr.NextPart()
+		if err != nil || part.FileName() != "" {
+			break
+		}
+		if part.FormName() == field {
+			value, _ := io.ReadAll(io.LimitReader(part, 1024))
+			token = string(value)
+			break
+		}
+	}
+	r.Body = struct {
+		io.Reader
+		io.Closer
+	}{io.MultiReader(&peeked, body), body}
+	return token
+}
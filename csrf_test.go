@@ -0,0 +1,176 @@
+package toolkit
+
+import (
+	"bytes"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+func csrfCookie(t *testing.T, handler http.Handler) *http.Cookie {
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest("GET", "http://example.com/form", nil))
+	for _, c := range rr.Result().Cookies() {
+		if c.Name == "csrf_token" {
+			return c
+		}
+	}
+	t.Fatal("no CSRF cookie was set")
+	return nil
+}
+
+func TestTools_CSRFMultipartUpload(t *testing.T) {
+	png, err := os.ReadFile("./testdata/img.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	testTools := Tools{allowedTypes: []string{"image/png"}}
+	uploadDir := t.TempDir()
+	var uploaded []*UploadedFile
+	handler := testTools.CSRF(CSRFOptions{Key: []byte("secret")}, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			return
+		}
+		files, err := testTools.UploadFiles(r, uploadDir)
+		if err != nil {
+			t.Error(err)
+		}
+		uploaded = files
+	}))
+	cookie := csrfCookie(t, handler)
+
+	for _, token := range []string{cookie.Value, "wrong"} {
+		body := &bytes.Buffer{}
+		writer := multipart.NewWriter(body)
+		_ = writer.WriteField("csrf_token", token)
+		part, _ := writer.CreateFormFile("file", "img.png")
+		_, _ = part.Write(png)
+		_ = writer.Close()
+
+		req := httptest.NewRequest("POST", "http://example.com/upload", body)
+		req.Header.Set("Content-Type", writer.FormDataContentType())
+		req.Header.Set("Origin", "http://example.com")
+		req.AddCookie(cookie)
+		rr := httptest.NewRecorder()
+		uploaded = nil
+		handler.ServeHTTP(rr, req)
+
+		if token == cookie.Value {
+			if rr.Code != http.StatusOK || len(uploaded) != 1 || uploaded[0].FileSize != int64(len(png)) {
+				t.Errorf("valid token: unexpected result %d %v", rr.Code, uploaded)
+			}
+		} else if rr.Code != http.StatusForbidden || uploaded != nil {
+			t.Errorf("invalid token: expected 403, got %d", rr.Code)
+		}
+	}
+}
+
+var csrfTests = []struct {
+	name    string
+	origin  string
+	referer string
+	header  bool
+	form    bool
+	bearer  bool
+	forged  bool
+	status  int
+}{
+	{name: "header token", header: true, status: http.StatusOK},
+	{name: "form token", form: true, status: http.StatusOK},
+	{name: "missing token", status: http.StatusForbidden},
+	{name: "same origin", origin: "http://example.com", header: true, status: http.StatusOK},
+	{name: "trusted origin", origin: "https://app.example.net", header: true, status: http.StatusOK},
+	{name: "cross origin", origin: "https://evil.com", header: true, status: http.StatusForbidden},
+	{name: "cross site referer", referer: "https://evil.com/page", header: true, status: http.StatusForbidden},
+	{name: "forged cookie", forged: true, header: true, status: http.StatusForbidden},
+	{name: "bearer token exempt", bearer: true, origin: "https://evil.com", status: http.StatusOK},
+}
+
+func TestTools_CSRF(t *testing.T) {
+	var testTools Tools
+	opts := CSRFOptions{
+		Key:            []byte("secret"),
+		TrustedOrigins: []string{"https://app.example.net"},
+		Exempt:         CSRFExemptTokenAuth,
+	}
+	handler := testTools.CSRF(opts, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	cookie := csrfCookie(t, handler)
+
+	for _, e := range csrfTests {
+		token := cookie.Value
+		if e.forged {
+			token = strings.Repeat("a", csrfTokenLength)
+		}
+		var req *http.Request
+		if e.form {
+			req = httptest.NewRequest("POST", "http://example.com/items", strings.NewReader("csrf_token="+token))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		} else {
+			req = httptest.NewRequest("POST", "http://example.com/items", nil)
+		}
+		if e.header {
+			req.Header.Set("X-CSRF-Token", token)
+		}
+		if e.origin != "" {
+			req.Header.Set("Origin", e.origin)
+		}
+		if e.referer != "" {
+			req.Header.Set("Referer", e.referer)
+		}
+		if e.bearer {
+			req.Header.Set("Authorization", "Bearer abc")
+		}
+		req.AddCookie(&http.Cookie{Name: "csrf_token", Value: token})
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		if rr.Code != e.status {
+			t.Errorf("%s: expected status %d, got %d", e.name, e.status, rr.Code)
+		}
+	}
+}
+
+type memoryCSRFStore struct {
+	token string
+}
+
+func (s *memoryCSRFStore) GetCSRFToken(r *http.Request) (string, error) {
+	return s.token, nil
+}
+
+func (s *memoryCSRFStore) SetCSRFToken(w http.ResponseWriter, r *http.Request, token string) error {
+	s.token = token
+	return nil
+}
+
+func TestTools_CSRFSynchronizerToken(t *testing.T) {
+	var testTools Tools
+	store := &memoryCSRFStore{}
+	var pageToken string
+	handler := testTools.CSRF(CSRFOptions{Store: store}, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		pageToken = CSRFToken(r)
+	}))
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest("GET", "/form", nil))
+	if pageToken == "" || pageToken != store.token || len(rr.Result().Cookies()) != 0 {
+		t.Fatalf("expected the token to be stored in the session, got %q", pageToken)
+	}
+
+	req := httptest.NewRequest("POST", "/form", nil)
+	req.Header.Set("X-CSRF-Token", pageToken)
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Errorf("expected the stored token to be accepted, got %d", rr.Code)
+	}
+
+	store.token = "rotated"
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	if rr.Code != http.StatusForbidden {
+		t.Errorf("expected a stale token to be rejected, got %d", rr.Code)
+	}
+}
@@ -0,0 +1,101 @@
+package toolkit
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/url"
+	"time"
+)
+
+// The record functions log an event and update the metrics for it.
+
+func (t *Tools) recordUploadAccepted(ctx context.Context, dir string, file *UploadedFile, contentType string) {
+	attrs := []slog.Attr{
+		slog.String("file", file.OriginalFileName),
+		slog.String("stored_as", file.NewFileName),
+		slog.Int64("size", file.FileSize),
+		slog.String("content_type", contentType),
+		slog.String("dir", dir),
+	}
+	if file.Status != "" {
+		attrs = append(attrs, slog.String("scan_status", string(file.Status)))
+	}
+	t.log(ctx, slog.LevelInfo, "upload accepted", attrs...)
+
+	metrics := t.Metrics()
+	metrics.Add(MetricUploads, 1)
+	metrics.Add(MetricUploadBytes, float64(file.FileSize))
+	metrics.Observe(MetricUploadSize, float64(file.FileSize))
+}
+
+// recordUploadRejected logs uploads refused because of what the client sent at
+// info level, infected files at warning level and server-side failures at
+// error level.
+func (t *Tools) recordUploadRejected(ctx context.Context, name string, size int64, err error) {
+	attrs := []slog.Attr{
+		slog.String("file", name),
+		slog.Int64("size", size),
+	}
+	var typeErr *FileTypeError
+	if errors.As(err, &typeErr) {
+		attrs = append(attrs, slog.String("content_type", typeErr.ContentType))
+	}
+	reason := uploadRejection(err)
+	t.Metrics().Add(MetricUploadRejections, 1, Label{Name: "reason", Value: reason})
+	attrs = append(attrs, slog.String("reason", reason), slog.String("error", err.Error()))
+	switch reason {
+	case "error":
+		t.log(ctx, slog.LevelError, "upload failed", attrs...)
+	case "infected":
+		t.log(ctx, slog.LevelWarn, "upload rejected", attrs...)
+	default:
+		t.log(ctx, slog.LevelInfo, "upload rejected", attrs...)
+	}
+}
+
+// uploadRejection names the reason an upload was refused, or returns "error"
+// when the failure is not the client's doing.
+func uploadRejection(err error) string {
+	var typeErr *FileTypeError
+	var quotaErr *QuotaExceededError
+	var infected *InfectedFileError
+	switch {
+	case errors.As(err, &typeErr):
+		return "type_not_allowed"
+	case errors.As(err, &quotaErr):
+		return "quota_exceeded"
+	case errors.As(err, &infected):
+		return "infected"
+	case errors.Is(err, errFileTooBig):
+		return "too_large"
+	case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+		return "canceled"
+	}
+	return "error"
+}
+
+func (t *Tools) recordRemoteCall(ctx context.Context, u *url.URL, status int, elapsed time.Duration, err error) {
+	// The query string may carry credentials, so only the location is logged.
+	location := *u
+	location.RawQuery = ""
+	location.Fragment = ""
+	attrs := []slog.Attr{
+		slog.String("url", location.Redacted()),
+		slog.Duration("latency", elapsed),
+	}
+	metrics := t.Metrics()
+	metrics.Add(MetricRemotePushAttempts, 1)
+	metrics.Observe(MetricRemotePushDuration, elapsed.Seconds())
+	if err != nil || status >= 400 {
+		metrics.Add(MetricRemotePushFailures, 1)
+	}
+	switch {
+	case err != nil:
+		t.log(ctx, slog.LevelError, "remote call failed", append(attrs, slog.String("error", err.Error()))...)
+	case status >= 400:
+		t.log(ctx, slog.LevelWarn, "remote call completed", append(attrs, slog.Int("status", status))...)
+	default:
+		t.log(ctx, slog.LevelInfo, "remote call completed", append(attrs, slog.Int("status", status))...)
+	}
+}
@@ -0,0 +1,134 @@
+package toolkit
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode"
+)
+
+const maxFilenameLength = 255
+
+var windowsReservedNames = map[string]bool{
+	"con": true, "prn": true, "aux": true, "nul": true,
+	"com1": true, "com2": true, "com3": true, "com4": true, "com5": true, "com6": true, "com7": true, "com8": true, "com9": true,
+	"lpt1": true, "lpt2": true, "lpt3": true, "lpt4": true, "lpt5": true, "lpt6": true, "lpt7": true, "lpt8": true, "lpt9": true,
+}
+
+// SanitizeFilename turns a client supplied file name into one that is safe to
+// use on any file system. Like Slugify it replaces runs of unsafe characters
+// with hyphens, but it keeps the case, Unicode letters, dots and underscores,
+// and preserves the extension. Directories are dropped, names reserved on
+// Windows get a trailing underscore, and the result is at most 255 bytes.
+func (t *Tools) SanitizeFilename(name string) (string, error) {
+	if i := strings.LastIndexAny(name, `/\`); i >= 0 {
+		name = name[i+1:]
+	}
+	ext := filepath.Ext(name)
+	if ext == name {
+		ext = ""
+	}
+	stem := sanitizeFilenamePart(strings.TrimSuffix(name, ext), func(r rune) bool {
+		return r == '.' || r == '_'
+	})
+	ext = sanitizeFilenamePart(strings.TrimPrefix(ext, "."), nil)
+	stem = strings.TrimLeft(stem, ".-")
+	stem = strings.TrimRight(stem, ".")
+	if stem == "" {
+		return "", errors.New("Filename is empty")
+	}
+	if base, rest, found := strings.Cut(stem, "."); windowsReservedNames[strings.ToLower(base)] {
+		stem = base + "_"
+		if found {
+			stem += "." + rest
+		}
+	}
+	if ext != "" {
+		ext = "." + ext
+	}
+
+	if len(ext) > maxFilenameLength/2 {
+		ext = ""
+	}
+	if len(stem)+len(ext) > maxFilenameLength {
+		stem = truncateUTF8(stem, maxFilenameLength-len(ext))
+	}
+	return stem + ext, nil
+}
+
+func sanitizeFilenamePart(s string, keep func(rune) bool) string {
+	var b strings.Builder
+	pendingHyphen := false
+	for _, r := range s {
+		if unicode.IsLetter(r) || unicode.IsMark(r) || unicode.IsNumber(r) || (keep != nil && keep(r)) {
+			if pendingHyphen && b.Len() > 0 {
+				b.WriteByte('-')
+			}
+			pendingHyphen = false
+			b.WriteRune(r)
+			continue
+		}
+		pendingHyphen = true
+	}
+	return b.String()
+}
+
+func truncateUTF8(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	for n > 0 && !isRuneStart(s[n]) {
+		n--
+	}
+	return s[:n]
+}
+
+func isRuneStart(b byte) bool {
+	return b&0xc0 != 0x80
+}
+
+// moveUnique moves src into dir under name, or under name-2, name-3, ... if
+// that name is taken, and returns the name it used. An existing file is never
+// replaced.
+func moveUnique(src, dir, name string) (string, error) {
+	ext := filepath.Ext(name)
+	stem := strings.TrimSuffix(name, ext)
+	for i := 1; i <= 1000; i++ {
+		candidate := name
+		if i > 1 {
+			suffix := fmt.Sprintf("-%d", i)
+			candidate = truncateUTF8(stem, maxFilenameLength-len(ext)-len(suffix)) + suffix + ext
+		}
+		err := moveNoClobber(src, filepath.Join(dir, candidate))
+		if errors.Is(err, fs.ErrExist) {
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+		return candidate, nil
+	}
+	return "", fmt.Errorf("Could not find a free name for %s", name)
+}
+
+// moveNoClobber renames src to dst, failing with fs.ErrExist if dst already
+// exists. Hard links make this atomic; on file systems without them the
+// destination is reserved with an exclusive create before the rename.
+func moveNoClobber(src, dst string) error {
+	err := os.Link(src, dst)
+	if err == nil {
+		return os.Remove(src)
+	}
+	if errors.Is(err, fs.ErrExist) {
+		return err
+	}
+	f, err := os.OpenFile(dst, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	_ = f.Close()
+	return os.Rename(src, dst)
+}
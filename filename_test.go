@@ -0,0 +1,80 @@
+package toolkit
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var sanitizeTests = []struct {
+	name          string
+	filename      string
+	expected      string
+	errorExpected bool
+}{
+	{name: "plain", filename: "report.pdf", expected: "report.pdf"},
+	{name: "unix path", filename: "../../etc/passwd", expected: "passwd"},
+	{name: "windows path", filename: `C:\Users\bob\My Report.docx`, expected: "My-Report.docx"},
+	{name: "control characters", filename: "evil\x00name\r\n.txt", expected: "evil-name.txt"},
+	{name: "windows characters", filename: `a<b>c:d"e|f?g*h.txt`, expected: "a-b-c-d-e-f-g-h.txt"},
+	{name: "unicode kept", filename: "Crème brûlée.jpg", expected: "Crème-brûlée.jpg"},
+	{name: "reserved name", filename: "CON.txt", expected: "CON_.txt"},
+	{name: "reserved name with extensions", filename: "aux.tar.gz", expected: "aux_.tar.gz"},
+	{name: "hidden file", filename: ".htaccess", expected: "htaccess"},
+	{name: "trailing dots", filename: "name...", expected: "name"},
+	{name: "hyphen runs", filename: "my - file.txt", expected: "my-file.txt"},
+	{name: "only symbols", filename: "???.txt", errorExpected: true},
+	{name: "empty", filename: "", errorExpected: true},
+}
+
+func TestTools_SanitizeFilename(t *testing.T) {
+	var testTools Tools
+	for _, e := range sanitizeTests {
+		name, err := testTools.SanitizeFilename(e.filename)
+		if err != nil && !e.errorExpected {
+			t.Errorf("%s - Error not expected, but got one : %s", e.name, err)
+		}
+		if err == nil && e.errorExpected {
+			t.Errorf("%s - Error expected, but got none.", e.name)
+		}
+		if name != e.expected {
+			t.Errorf("%s - Expected %q, got %q", e.name, e.expected, name)
+		}
+	}
+
+	long, err := testTools.SanitizeFilename(strings.Repeat("é", 200) + ".txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(long) > 255 || !strings.HasSuffix(long, ".txt") || !strings.HasPrefix(long, "é") {
+		t.Errorf("Long name was not truncated correctly: %d bytes", len(long))
+	}
+}
+
+func TestTools_UploadFilesNoOverwrite(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "a.txt"), []byte("existing"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	var testTools Tools
+	request := newMultipartRequest(t, map[string][]byte{"a.txt": []byte("new")}, "a.txt")
+	file, err := testTools.UploadOneFile(request, dir, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if file.NewFileName != "a-2.txt" {
+		t.Errorf("Expected a-2.txt, got %s", file.NewFileName)
+	}
+	data, _ := os.ReadFile(filepath.Join(dir, "a.txt"))
+	if string(data) != "existing" {
+		t.Error("Existing file was overwritten")
+	}
+	info, err := os.Stat(filepath.Join(dir, file.NewFileName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0644 {
+		t.Errorf("Wrong permissions on uploaded file: %s", info.Mode().Perm())
+	}
+}
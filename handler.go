@@ -0,0 +1,199 @@
+package toolkit
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"runtime/debug"
+)
+
+// HandlerFunc is an http.HandlerFunc that returns its error instead of
+// writing it. Use Handle to turn it into an http.Handler.
+type HandlerFunc func(w http.ResponseWriter, r *http.Request) error
+
+// HTTPError is an error with the status code it should be reported with.
+type HTTPError struct {
+	Status int
+	Err    error
+}
+
+func NewHTTPError(status int, err error) *HTTPError {
+	return &HTTPError{Status: status, Err: err}
+}
+
+// Error returns the message of Err, or the status text when Err is nil.
+func (e *HTTPError) Error() string {
+	if e.Err == nil {
+		return http.StatusText(e.Status)
+	}
+	return e.Err.Error()
+}
+
+func (e *HTTPError) Unwrap() error {
+	return e.Err
+}
+
+// ProblemDetails is an RFC 9457 problem details object.
+type ProblemDetails struct {
+	Type      string `json:"type"`
+	Title     string `json:"title"`
+	Status    int    `json:"status"`
+	Detail    string `json:"detail,omitempty"`
+	Instance  string `json:"instance,omitempty"`
+	RequestID string `json:"request_id,omitempty"`
+}
+
+// ProblemJSON is the application/problem+json counterpart of ErrorJSON.
+func (t *Tools) ProblemJSON(w http.ResponseWriter, r *http.Request, err error, status ...int) error {
+	statusCode := http.StatusBadRequest
+	if len(status) > 0 {
+		statusCode = status[0]
+	}
+	problem := ProblemDetails{
+		Type:      "about:blank",
+		Title:     http.StatusText(statusCode),
+		Status:    statusCode,
+		Detail:    err.Error(),
+		Instance:  r.URL.Path,
+		RequestID: RequestIDFromContext(r.Context()),
+	}
+	return t.writeJSON(w, statusCode, "application/problem+json", problem)
+}
+
+// Handle adapts h to an http.Handler. A returned error is rendered with
+// ErrorJSON, or ProblemJSON when UseProblemJSON is set, using the status of an
+// *HTTPError or one matching the errors of this package, and 500 otherwise.
+// The message of errors reported with a 500 is not shown to the client. Panics
+// are handled as by Recover.
+func (t *Tools) Handle(h HandlerFunc) http.Handler {
+	return t.Recover(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := h(w, r); err != nil {
+			t.handleError(w, r, err)
+		}
+	}))
+}
+
+// Recover turns a panic in next into a 500 response and logs it with its
+// stack trace. If next had already started the response it is left as is,
+// since the status can no longer be changed.
+func (t *Tools) Recover(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rw, ok := w.(*responseWriter)
+		if !ok {
+			rw = &responseWriter{ResponseWriter: w}
+		}
+		defer func() {
+			v := recover()
+			if v == nil {
+				return
+			}
+			if v == http.ErrAbortHandler {
+				panic(v)
+			}
+			t.log(r.Context(), slog.LevelError, "panic recovered",
+				slog.String("method", r.Method),
+				slog.String("path", r.URL.Path),
+				slog.String("panic", fmt.Sprint(v)),
+				slog.String("stack", string(debug.Stack())))
+			if !rw.wroteHeader {
+				t.writeError(rw, r, errors.New(http.StatusText(http.StatusInternalServerError)), http.StatusInternalServerError)
+			}
+		}()
+		next.ServeHTTP(rw, r)
+	})
+}
+
+func (t *Tools) handleError(w http.ResponseWriter, r *http.Request, err error) {
+	status := errorStatus(err)
+	level := slog.LevelInfo
+	if status >= 500 {
+		level = slog.LevelError
+	}
+	t.log(r.Context(), level, "request failed",
+		slog.String("method", r.Method),
+		slog.String("path", r.URL.Path),
+		slog.Int("status", status),
+		slog.String("error", err.Error()))
+
+	if rw, ok := w.(*responseWriter); ok && rw.wroteHeader {
+		return
+	}
+	var httpErr *HTTPError
+	if status >= 500 && !errors.As(err, &httpErr) {
+		err = errors.New(http.StatusText(status))
+	}
+	t.writeError(w, r, err, status)
+}
+
+func (t *Tools) writeError(w http.ResponseWriter, r *http.Request, err error, status int) {
+	if t.useProblemJSON {
+		_ = t.ProblemJSON(w, r, err, status)
+		return
+	}
+	_ = t.ErrorJSON(w, err, status)
+}
+
+func errorStatus(err error) int {
+	var httpErr *HTTPError
+	var maxBytes *http.MaxBytesError
+	var typeErr *FileTypeError
+	var quotaErr *QuotaExceededError
+	var infected *InfectedFileError
+	switch {
+	case errors.As(err, &httpErr):
+		return httpErr.Status
+	case errors.As(err, &maxBytes), errors.Is(err, errFileTooBig), errors.As(err, &quotaErr):
+		return http.StatusRequestEntityTooLarge
+	case errors.As(err, &typeErr):
+		return http.StatusUnsupportedMediaType
+	case errors.As(err, &infected):
+		return http.StatusUnprocessableEntity
+	case errors.Is(err, ErrSignedURLExpired):
+		return http.StatusGone
+	case errors.Is(err, ErrSignedURLInvalid):
+		return http.StatusForbidden
+	}
+	return http.StatusInternalServerError
+}
+
+// responseWriter records whether the response has been started, so that an
+// error is never written after a handler has already sent its status.
+type responseWriter struct {
+	http.ResponseWriter
+	wroteHeader bool
+}
+
+func (w *responseWriter) WriteHeader(status int) {
+	if status >= 200 || status == http.StatusSwitchingProtocols {
+		w.wroteHeader = true
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *responseWriter) Write(b []byte) (int, error) {
+	w.wroteHeader = true
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *responseWriter) Flush() {
+	w.wroteHeader = true
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (w *responseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("Response does not support hijacking")
+	}
+	w.wroteHeader = true
+	return h.Hijack()
+}
+
+func (w *responseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
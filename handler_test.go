@@ -0,0 +1,125 @@
+package toolkit
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+var handleTests = []struct {
+	name            string
+	problemJSON     bool
+	handler         HandlerFunc
+	expectedStatus  int
+	expectedMessage string
+}{
+	{
+		name: "http error",
+		handler: func(w http.ResponseWriter, r *http.Request) error {
+			return NewHTTPError(http.StatusNotFound, errors.New("No such item"))
+		},
+		expectedStatus:  http.StatusNotFound,
+		expectedMessage: "No such item",
+	},
+	{
+		name:            "http error without an error",
+		handler:         func(w http.ResponseWriter, r *http.Request) error { return NewHTTPError(http.StatusNotFound, nil) },
+		expectedStatus:  http.StatusNotFound,
+		expectedMessage: "Not Found",
+	},
+	{
+		name:            "package error",
+		handler:         func(w http.ResponseWriter, r *http.Request) error { return &FileTypeError{ContentType: "text/plain"} },
+		expectedStatus:  http.StatusUnsupportedMediaType,
+		expectedMessage: "File type is not allowed",
+	},
+	{
+		name:            "internal error is hidden",
+		handler:         func(w http.ResponseWriter, r *http.Request) error { return errors.New("db password is wrong") },
+		expectedStatus:  http.StatusInternalServerError,
+		expectedMessage: "Internal Server Error",
+	},
+	{
+		name:            "panic",
+		handler:         func(w http.ResponseWriter, r *http.Request) error { panic("boom") },
+		expectedStatus:  http.StatusInternalServerError,
+		expectedMessage: "Internal Server Error",
+	},
+	{
+		name:        "problem json",
+		problemJSON: true,
+		handler: func(w http.ResponseWriter, r *http.Request) error {
+			return NewHTTPError(http.StatusConflict, errors.New("Already exists"))
+		},
+		expectedStatus:  http.StatusConflict,
+		expectedMessage: "Already exists",
+	},
+}
+
+func TestTools_Handle(t *testing.T) {
+	for _, e := range handleTests {
+		var buf bytes.Buffer
+		testTools := Tools{useProblemJSON: e.problemJSON, logger: newTestLogger(&buf)}
+		rr := httptest.NewRecorder()
+		testTools.Handle(e.handler).ServeHTTP(rr, httptest.NewRequest("GET", "/items/1", nil))
+
+		if rr.Code != e.expectedStatus {
+			t.Errorf("%s: expected status %d, got %d", e.name, e.expectedStatus, rr.Code)
+		}
+		if e.problemJSON {
+			var problem ProblemDetails
+			if err := json.NewDecoder(rr.Body).Decode(&problem); err != nil {
+				t.Fatal(err)
+			}
+			if rr.Header().Get("Content-Type") != "application/problem+json" {
+				t.Errorf("%s: unexpected content type %q", e.name, rr.Header().Get("Content-Type"))
+			}
+			if problem.Status != e.expectedStatus || problem.Detail != e.expectedMessage || problem.Instance != "/items/1" {
+				t.Errorf("%s: unexpected problem %+v", e.name, problem)
+			}
+		} else {
+			var payload JSONResponse
+			if err := json.NewDecoder(rr.Body).Decode(&payload); err != nil {
+				t.Fatal(err)
+			}
+			if !payload.Error || payload.Message != e.expectedMessage {
+				t.Errorf("%s: unexpected payload %+v", e.name, payload)
+			}
+		}
+		if e.name == "panic" && !strings.Contains(buf.String(), "handler_test.go") {
+			t.Errorf("%s: stack trace was not logged: %s", e.name, buf.String())
+		}
+	}
+}
+
+func TestTools_RecoverAfterWrite(t *testing.T) {
+	var testTools Tools
+	handler := testTools.Handle(func(w http.ResponseWriter, r *http.Request) error {
+		w.WriteHeader(http.StatusAccepted)
+		_, _ = w.Write([]byte("partial"))
+		panic("boom")
+	})
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest("GET", "/", nil))
+
+	if rr.Code != http.StatusAccepted || rr.Body.String() != "partial" {
+		t.Errorf("response was changed after it started: %d %q", rr.Code, rr.Body.String())
+	}
+}
+
+func TestTools_RecoverAbortHandler(t *testing.T) {
+	var testTools Tools
+	handler := testTools.Recover(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic(http.ErrAbortHandler)
+	}))
+	defer func() {
+		if v := recover(); v != http.ErrAbortHandler {
+			t.Errorf("expected ErrAbortHandler to be re-raised, got %v", v)
+		}
+	}()
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+}
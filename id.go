@@ -0,0 +1,260 @@
+package toolkit
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+type IDScheme string
+
+const (
+	IDRandomString IDScheme = "random"
+	IDUUIDv4       IDScheme = "uuidv4"
+	IDUUIDv7       IDScheme = "uuidv7"
+	IDULID         IDScheme = "ulid"
+	IDNanoID       IDScheme = "nanoid"
+)
+
+// NewID returns a new identifier in the given scheme. An empty scheme is the
+// same as IDRandomString, a 25 characters GenerateRandomString value.
+func (t *Tools) NewID(scheme IDScheme) (string, error) {
+	switch scheme {
+	case "", IDRandomString:
+		return t.GenerateToken(25)
+	case IDUUIDv4:
+		u, err := NewUUIDv4()
+		return u.String(), err
+	case IDUUIDv7:
+		u, err := NewUUIDv7()
+		return u.String(), err
+	case IDULID:
+		u, err := NewULID()
+		return u.String(), err
+	case IDNanoID:
+		return NewNanoID()
+	default:
+		return "", fmt.Errorf("Unknown ID scheme %q", scheme)
+	}
+}
+
+type UUID [16]byte
+
+func NewUUIDv4() (UUID, error) {
+	var u UUID
+	if _, err := rand.Read(u[:]); err != nil {
+		return UUID{}, err
+	}
+	u[6] = u[6]&0x0f | 0x40
+	u[8] = u[8]&0x3f | 0x80
+	return u, nil
+}
+
+// NewUUIDv7 returns a time-ordered UUID. UUIDs created within the same
+// millisecond, or while the clock goes backwards, reuse the previous timestamp
+// and increment the random part, so they always sort after the previous one.
+func NewUUIDv7() (UUID, error) {
+	ms, hi, lo, err := uuidV7Clock.next(12, 62)
+	if err != nil {
+		return UUID{}, err
+	}
+	var u UUID
+	putUint48(u[:6], ms)
+	binary.BigEndian.PutUint16(u[6:8], 0x7000|uint16(hi))
+	binary.BigEndian.PutUint64(u[8:], 0x8000000000000000|lo)
+	return u, nil
+}
+
+func ParseUUID(s string) (UUID, error) {
+	var u UUID
+	switch len(s) {
+	case 36:
+		if s[8] != '-' || s[13] != '-' || s[18] != '-' || s[23] != '-' {
+			return UUID{}, errors.New("UUID is badly formed")
+		}
+		s = s[:8] + s[9:13] + s[14:18] + s[19:23] + s[24:]
+	case 32:
+	default:
+		return UUID{}, errors.New("UUID is badly formed")
+	}
+	if _, err := hex.Decode(u[:], []byte(s)); err != nil {
+		return UUID{}, errors.New("UUID is badly formed")
+	}
+	return u, nil
+}
+
+func (u UUID) String() string {
+	var buff [36]byte
+	hex.Encode(buff[0:8], u[0:4])
+	buff[8] = '-'
+	hex.Encode(buff[9:13], u[4:6])
+	buff[13] = '-'
+	hex.Encode(buff[14:18], u[6:8])
+	buff[18] = '-'
+	hex.Encode(buff[19:23], u[8:10])
+	buff[23] = '-'
+	hex.Encode(buff[24:], u[10:])
+	return string(buff[:])
+}
+
+func (u UUID) Version() int {
+	return int(u[6] >> 4)
+}
+
+// Time returns the creation time of a version 7 UUID, and the zero time for
+// other versions.
+func (u UUID) Time() time.Time {
+	if u.Version() != 7 {
+		return time.Time{}
+	}
+	return time.UnixMilli(int64(uint48(u[:6])))
+}
+
+type ULID [16]byte
+
+// NewULID returns a ULID that is monotonic within the same millisecond, as
+// described in the ULID specification.
+func NewULID() (ULID, error) {
+	ms, hi, lo, err := ulidClock.next(16, 64)
+	if err != nil {
+		return ULID{}, err
+	}
+	var u ULID
+	putUint48(u[:6], ms)
+	binary.BigEndian.PutUint16(u[6:8], uint16(hi))
+	binary.BigEndian.PutUint64(u[8:], lo)
+	return u, nil
+}
+
+func ParseULID(s string) (ULID, error) {
+	if len(s) != 26 {
+		return ULID{}, errors.New("ULID must be 26 characters long")
+	}
+	var hi, lo uint64
+	for i := 0; i < len(s); i++ {
+		v := crockfordValue(s[i])
+		if v < 0 || (i == 0 && v > 7) {
+			return ULID{}, errors.New("ULID is badly formed")
+		}
+		hi = hi<<5 | lo>>59
+		lo = lo<<5 | uint64(v)
+	}
+	var u ULID
+	binary.BigEndian.PutUint64(u[:8], hi)
+	binary.BigEndian.PutUint64(u[8:], lo)
+	return u, nil
+}
+
+func (u ULID) String() string {
+	hi, lo := binary.BigEndian.Uint64(u[:8]), binary.BigEndian.Uint64(u[8:])
+	var buff [26]byte
+	for i := len(buff) - 1; i >= 0; i-- {
+		buff[i] = AlphabetCrockford[lo&0x1f]
+		lo = lo>>5 | hi<<59
+		hi >>= 5
+	}
+	return string(buff[:])
+}
+
+func (u ULID) Time() time.Time {
+	return time.UnixMilli(int64(uint48(u[:6])))
+}
+
+func crockfordValue(c byte) int {
+	switch c {
+	case 'o', 'O':
+		return 0
+	case 'i', 'I', 'l', 'L':
+		return 1
+	}
+	if c >= 'a' && c <= 'z' {
+		c -= 'a' - 'A'
+	}
+	return strings.IndexByte(AlphabetCrockford, c)
+}
+
+const nanoIDSize = 21
+
+// NewNanoID returns a NanoID, 21 characters from the URL-safe alphabet unless
+// another size is given.
+func NewNanoID(size ...int) (string, error) {
+	n := nanoIDSize
+	if len(size) > 0 {
+		n = size[0]
+	}
+	return TokenGenerator{Alphabet: AlphabetURLSafe}.Generate(n)
+}
+
+func ValidateNanoID(s string, size ...int) error {
+	n := nanoIDSize
+	if len(size) > 0 {
+		n = size[0]
+	}
+	if len(s) != n {
+		return fmt.Errorf("NanoID must be %d characters long", n)
+	}
+	if strings.Trim(s, AlphabetURLSafe) != "" {
+		return errors.New("NanoID contains invalid characters")
+	}
+	return nil
+}
+
+// monotonicClock hands out a millisecond timestamp together with a random
+// value of hiBits+loBits bits. When called again within the same millisecond
+// it increments the previous random value instead of drawing a new one.
+type monotonicClock struct {
+	mu sync.Mutex
+	ms uint64
+	hi uint64
+	lo uint64
+}
+
+var (
+	uuidV7Clock monotonicClock
+	ulidClock   monotonicClock
+)
+
+func (c *monotonicClock) next(hiBits, loBits uint) (uint64, uint64, uint64, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	ms := uint64(time.Now().UnixMilli())
+	if ms <= c.ms {
+		c.lo = (c.lo + 1) & (1<<loBits - 1)
+		if c.lo == 0 {
+			c.hi = (c.hi + 1) & (1<<hiBits - 1)
+		}
+		if c.lo != 0 || c.hi != 0 {
+			return c.ms, c.hi, c.lo, nil
+		}
+		// The random part overflowed, move on to the next millisecond.
+		ms = c.ms + 1
+	}
+
+	var buff [16]byte
+	if _, err := rand.Read(buff[:]); err != nil {
+		return 0, 0, 0, err
+	}
+	c.ms = ms
+	c.hi = binary.BigEndian.Uint64(buff[:8]) & (1<<hiBits - 1)
+	c.lo = binary.BigEndian.Uint64(buff[8:]) & (1<<loBits - 1)
+	return c.ms, c.hi, c.lo, nil
+}
+
+func putUint48(b []byte, v uint64) {
+	b[0] = byte(v >> 40)
+	b[1] = byte(v >> 32)
+	b[2] = byte(v >> 24)
+	b[3] = byte(v >> 16)
+	b[4] = byte(v >> 8)
+	b[5] = byte(v)
+}
+
+func uint48(b []byte) uint64 {
+	return uint64(b[0])<<40 | uint64(b[1])<<32 | uint64(b[2])<<24 | uint64(b[3])<<16 | uint64(b[4])<<8 | uint64(b[5])
+}
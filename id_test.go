@@ -0,0 +1,150 @@
+package toolkit
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestUUID(t *testing.T) {
+	v4, err := NewUUIDv4()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v4.Version() != 4 || v4[8]&0xc0 != 0x80 {
+		t.Errorf("Wrong version or variant for %s", v4)
+	}
+	parsed, err := ParseUUID(v4.String())
+	if err != nil || parsed != v4 {
+		t.Errorf("Parsing %s gave %s, %v", v4, parsed, err)
+	}
+	parsed, err = ParseUUID(strings.ReplaceAll(v4.String(), "-", ""))
+	if err != nil || parsed != v4 {
+		t.Errorf("Parsing %s without hyphens gave %s, %v", v4, parsed, err)
+	}
+
+	before := time.Now().Truncate(time.Millisecond)
+	v7, err := NewUUIDv7()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v7.Version() != 7 || v7[8]&0xc0 != 0x80 {
+		t.Errorf("Wrong version or variant for %s", v7)
+	}
+	if v7.Time().Before(before) || v7.Time().After(time.Now()) {
+		t.Errorf("Wrong time in %s: %s", v7, v7.Time())
+	}
+
+	for _, s := range []string{"", "not-a-uuid", "0189b2e4-5b7a-7c3e-8f5d-9a1b2c3d4e5", "0189b2e4x5b7a-7c3e-8f5d-9a1b2c3d4e5f"} {
+		if _, err := ParseUUID(s); err == nil {
+			t.Errorf("Error expected when parsing %q, but got none.", s)
+		}
+	}
+}
+
+func TestUUIDv7_Monotonic(t *testing.T) {
+	previous, _ := NewUUIDv7()
+	for i := 0; i < 10000; i++ {
+		u, err := NewUUIDv7()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if u.String() <= previous.String() {
+			t.Fatalf("%s is not greater than %s", u, previous)
+		}
+		previous = u
+	}
+}
+
+func TestULID(t *testing.T) {
+	previous, _ := NewULID()
+	for i := 0; i < 10000; i++ {
+		u, err := NewULID()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if u.String() <= previous.String() {
+			t.Fatalf("%s is not greater than %s", u, previous)
+		}
+		previous = u
+	}
+
+	s := previous.String()
+	if len(s) != 26 {
+		t.Errorf("Wrong ULID length: %s", s)
+	}
+	parsed, err := ParseULID(strings.ToLower(s))
+	if err != nil || parsed != previous {
+		t.Errorf("Parsing %s gave %s, %v", s, parsed, err)
+	}
+	if time.Since(previous.Time()) > time.Minute {
+		t.Errorf("Wrong time in %s: %s", s, previous.Time())
+	}
+
+	known, err := ParseULID("01ARZ3NDEKTSV4RRFFQ69G5FAV")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if known.Time().UnixMilli() != 1469922850259 {
+		t.Errorf("Wrong time for known ULID: %d", known.Time().UnixMilli())
+	}
+
+	for _, s := range []string{"", "01ARZ3NDEKTSV4RRFFQ69G5FA", "81ARZ3NDEKTSV4RRFFQ69G5FAV", "01ARZ3NDEKTSV4RRFFQ69G5FAU"} {
+		if _, err := ParseULID(s); err == nil {
+			t.Errorf("Error expected when parsing %q, but got none.", s)
+		}
+	}
+}
+
+func TestNanoID(t *testing.T) {
+	id, err := NewNanoID()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = ValidateNanoID(id); err != nil {
+		t.Error(err)
+	}
+	id, _ = NewNanoID(10)
+	if err = ValidateNanoID(id, 10); err != nil {
+		t.Error(err)
+	}
+	if err = ValidateNanoID("abc+defghijklmnopqrst"); err == nil {
+		t.Error("Error expected for invalid characters, but got none.")
+	}
+}
+
+var idSchemeTests = []struct {
+	scheme   IDScheme
+	validate func(string) error
+}{
+	{scheme: IDUUIDv4, validate: func(s string) error { _, err := ParseUUID(s); return err }},
+	{scheme: IDUUIDv7, validate: func(s string) error { _, err := ParseUUID(s); return err }},
+	{scheme: IDULID, validate: func(s string) error { _, err := ParseULID(s); return err }},
+	{scheme: IDNanoID, validate: func(s string) error { return ValidateNanoID(s) }},
+}
+
+func TestTools_UploadFilesIDScheme(t *testing.T) {
+	for _, e := range idSchemeTests {
+		var testTools Tools
+		testTools.uploadIDScheme = e.scheme
+		request := newMultipartRequest(t, map[string][]byte{"a.txt": []byte("hello")}, "a.txt")
+		file, err := testTools.UploadOneFile(request, t.TempDir())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if filepath.Ext(file.NewFileName) != ".txt" {
+			t.Errorf("%s - Extension was not kept: %s", e.scheme, file.NewFileName)
+		}
+		if err = e.validate(strings.TrimSuffix(file.NewFileName, ".txt")); err != nil {
+			t.Errorf("%s - Invalid file name %s: %s", e.scheme, file.NewFileName, err)
+		}
+	}
+
+	var testTools Tools
+	testTools.uploadIDScheme = "unknown"
+	request := newMultipartRequest(t, map[string][]byte{"a.txt": []byte("hello")}, "a.txt")
+	if _, err := testTools.UploadOneFile(request, t.TempDir()); err == nil {
+		t.Error("Error expected for an unknown ID scheme, but got none.")
+	}
+}
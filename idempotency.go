@@ -0,0 +1,322 @@
+package toolkit
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	maxIdempotencyKeyLength = 255
+	// maxIdempotentResponse bounds the responses kept for replay; larger
+	// ones are sent but not stored, so a retry runs the handler again.
+	maxIdempotentResponse = 1024 * 1024
+)
+
+var (
+	ErrIdempotencyKeyRequired = errors.New("Idempotency-Key header is required")
+	ErrIdempotencyKeyInvalid  = errors.New("Idempotency-Key header is invalid")
+	ErrIdempotencyInFlight    = errors.New("A request with this Idempotency-Key is still being processed")
+	ErrIdempotencyMismatch    = errors.New("Idempotency-Key was already used for a different request")
+)
+
+// IdempotencyRecord is what an IdempotencyStore keeps for a key: the
+// fingerprint of the request that first used it and, once the handler has
+// completed, its response.
+type IdempotencyRecord struct {
+	Fingerprint string      `json:"fingerprint"`
+	Completed   bool        `json:"completed"`
+	Status      int         `json:"status,omitempty"`
+	Header      http.Header `json:"header,omitempty"`
+	Body        []byte      `json:"body,omitempty"`
+}
+
+// IdempotencyStore keeps IdempotencyRecords for ttl. Reserve must be atomic:
+// it stores rec unless key already has a record, in which case it returns
+// that record instead, so that only one of several concurrent requests runs
+// the handler.
+type IdempotencyStore interface {
+	Reserve(ctx context.Context, key string, rec *IdempotencyRecord, ttl time.Duration) (*IdempotencyRecord, error)
+	Save(ctx context.Context, key string, rec *IdempotencyRecord, ttl time.Duration) error
+	Delete(ctx context.Context, key string) error
+}
+
+type idempotencyEntry struct {
+	rec     IdempotencyRecord
+	expires time.Time
+}
+
+// MemoryIdempotencyStore keeps records in memory, for a single instance.
+// Expired records are dropped as new keys are reserved.
+type MemoryIdempotencyStore struct {
+	mu        sync.Mutex
+	entries   map[string]idempotencyEntry
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+func (m *MemoryIdempotencyStore) clock() time.Time {
+	if m.now != nil {
+		return m.now()
+	}
+	return time.Now()
+}
+
+func (m *MemoryIdempotencyStore) Reserve(ctx context.Context, key string, rec *IdempotencyRecord, ttl time.Duration) (*IdempotencyRecord, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.entries == nil {
+		m.entries = make(map[string]idempotencyEntry)
+	}
+	now := m.clock()
+	if now.Sub(m.lastSweep) > time.Minute {
+		for k, e := range m.entries {
+			if now.After(e.expires) {
+				delete(m.entries, k)
+			}
+		}
+		m.lastSweep = now
+	}
+	if e, ok := m.entries[key]; ok && !now.After(e.expires) {
+		existing := e.rec
+		return &existing, nil
+	}
+	m.entries[key] = idempotencyEntry{rec: *rec, expires: now.Add(ttl)}
+	return nil, nil
+}
+
+func (m *MemoryIdempotencyStore) Save(ctx context.Context, key string, rec *IdempotencyRecord, ttl time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.entries == nil {
+		m.entries = make(map[string]idempotencyEntry)
+	}
+	m.entries[key] = idempotencyEntry{rec: *rec, expires: m.clock().Add(ttl)}
+	return nil
+}
+
+func (m *MemoryIdempotencyStore) Delete(ctx context.Context, key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.entries, key)
+	return nil
+}
+
+// IdempotencyOptions configures the Idempotency middleware. Records are kept
+// in Store, a MemoryIdempotencyStore of the handler's own when unset, for TTL,
+// 24 hours by default. While its first request runs, a key is only reserved
+// for LockTTL, one minute by default, so that a key whose request never
+// completed, after a crash for instance, can be used again soon; LockTTL
+// should be longer than the handler takes. Keys are read from the HeaderName
+// header, Idempotency-Key by default. Requests without a key are served as
+// usual unless Required is set. Scope, when set, returns the client a request
+// comes from, such as its user ID, so that clients cannot replay each other's
+// responses by guessing keys.
+type IdempotencyOptions struct {
+	Store      IdempotencyStore
+	TTL        time.Duration
+	LockTTL    time.Duration
+	HeaderName string
+	Required   bool
+	Scope      func(r *http.Request) string
+}
+
+func (o IdempotencyOptions) ttl() time.Duration {
+	if o.TTL > 0 {
+		return o.TTL
+	}
+	return 24 * time.Hour
+}
+
+func (o IdempotencyOptions) lockTTL() time.Duration {
+	if o.LockTTL > 0 {
+		return o.LockTTL
+	}
+	return time.Minute
+}
+
+func (o IdempotencyOptions) headerName() string {
+	if o.HeaderName != "" {
+		return o.HeaderName
+	}
+	return "Idempotency-Key"
+}
+
+// Idempotency makes the POST and PATCH requests of next safe to retry. The
+// first request with a given key runs next and its response is stored; later
+// requests with the same key and body get that response again, with an
+// Idempotent-Replayed header, without running next. A repeat arriving while
+// the first request is still running gets a 409, and a key reused with a
+// different body a 422. Responses with a 429 or 5xx status are not stored, so
+// that the request can be retried.
+func (t *Tools) Idempotency(opts IdempotencyOptions, next http.Handler) http.Handler {
+	if opts.Store == nil {
+		opts.Store = &MemoryIdempotencyStore{}
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost && r.Method != http.MethodPatch {
+			next.ServeHTTP(w, r)
+			return
+		}
+		key := r.Header.Get(opts.headerName())
+		switch {
+		case key == "" && opts.Required:
+			_ = t.ErrorJSON(w, ErrIdempotencyKeyRequired)
+			return
+		case key == "":
+			next.ServeHTTP(w, r)
+			return
+		case len(key) > maxIdempotencyKeyLength:
+			_ = t.ErrorJSON(w, ErrIdempotencyKeyInvalid)
+			return
+		}
+
+		fingerprint, err := t.requestFingerprint(w, r)
+		if err != nil {
+			_ = t.ErrorJSON(w, err, http.StatusRequestEntityTooLarge)
+			return
+		}
+		scope := ""
+		if opts.Scope != nil {
+			scope = opts.Scope(r)
+		}
+		sum := sha256.Sum256([]byte(scope + "\n" + key))
+		storeKey := hex.EncodeToString(sum[:])
+
+		ctx := r.Context()
+		existing, err := opts.Store.Reserve(ctx, storeKey, &IdempotencyRecord{Fingerprint: fingerprint}, opts.lockTTL())
+		if err != nil {
+			t.log(ctx, slog.LevelError, "idempotency store failed", slog.String("error", err.Error()))
+			_ = t.ErrorJSON(w, errors.New(http.StatusText(http.StatusInternalServerError)), http.StatusInternalServerError)
+			return
+		}
+		switch {
+		case existing != nil && existing.Fingerprint != fingerprint:
+			_ = t.ErrorJSON(w, ErrIdempotencyMismatch, http.StatusUnprocessableEntity)
+			return
+		case existing != nil && !existing.Completed:
+			w.Header().Set("Retry-After", "1")
+			_ = t.ErrorJSON(w, ErrIdempotencyInFlight, http.StatusConflict)
+			return
+		case existing != nil:
+			t.log(ctx, slog.LevelInfo, "idempotent response replayed",
+				slog.String("method", r.Method),
+				slog.String("path", r.URL.Path))
+			h := w.Header()
+			for name, values := range existing.Header {
+				if _, ok := h[name]; !ok {
+					h[name] = values
+				}
+			}
+			h.Set("Idempotent-Replayed", "true")
+			w.WriteHeader(existing.Status)
+			_, _ = w.Write(existing.Body)
+			return
+		}
+
+		rw := &idempotentWriter{ResponseWriter: w}
+		defer func() {
+			if p := recover(); p != nil {
+				_ = opts.Store.Delete(ctx, storeKey)
+				panic(p)
+			}
+		}()
+		next.ServeHTTP(rw, r)
+
+		if rw.status == 0 {
+			rw.WriteHeader(http.StatusOK)
+		}
+		if rw.status >= 500 || rw.status == http.StatusTooManyRequests || rw.truncated {
+			err = opts.Store.Delete(ctx, storeKey)
+		} else {
+			err = opts.Store.Save(ctx, storeKey, &IdempotencyRecord{
+				Fingerprint: fingerprint,
+				Completed:   true,
+				Status:      rw.status,
+				Header:      rw.header,
+				Body:        rw.body.Bytes(),
+			}, opts.ttl())
+		}
+		if err != nil {
+			t.log(ctx, slog.LevelError, "idempotency store failed", slog.String("error", err.Error()))
+		}
+	})
+}
+
+// requestFingerprint hashes the method, URI and body of r, restoring the body
+// for the handler. Bodies are bounded by MaxJSONSize.
+func (t *Tools) requestFingerprint(w http.ResponseWriter, r *http.Request) (string, error) {
+	maxBytes := t.MaxJSONSize()
+	var body []byte
+	if r.Body != nil {
+		var err error
+		body, err = io.ReadAll(io.LimitReader(r.Body, int64(maxBytes)+1))
+		if err != nil {
+			return "", err
+		}
+		if len(body) > maxBytes {
+			return "", errors.New("Request body is too large")
+		}
+		r.Body.Close()
+		r.Body = io.NopCloser(bytes.NewReader(body))
+	}
+	h := sha256.New()
+	h.Write([]byte(r.Method + " " + r.URL.RequestURI() + "\n"))
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// idempotentWriter keeps a copy of the response for replay. Set-Cookie headers
+// are left out of the copy, as they belong to the client that made the first
+// request.
+type idempotentWriter struct {
+	http.ResponseWriter
+	status    int
+	header    http.Header
+	body      bytes.Buffer
+	truncated bool
+}
+
+func (w *idempotentWriter) WriteHeader(status int) {
+	if w.status == 0 && status >= 200 {
+		w.status = status
+		w.header = w.Header().Clone()
+		w.header.Del("Set-Cookie")
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *idempotentWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.WriteHeader(http.StatusOK)
+	}
+	if !w.truncated {
+		if w.body.Len()+len(b) > maxIdempotentResponse {
+			w.truncated = true
+			w.body.Reset()
+		} else {
+			w.body.Write(b)
+		}
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *idempotentWriter) Flush() {
+	if w.status == 0 {
+		w.WriteHeader(http.StatusOK)
+	}
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (w *idempotentWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
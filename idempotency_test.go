@@ -0,0 +1,276 @@
+package toolkit
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func idempotentRequest(method, key, body string) *http.Request {
+	req := httptest.NewRequest(method, "/orders", strings.NewReader(body))
+	if key != "" {
+		req.Header.Set("Idempotency-Key", key)
+	}
+	return req
+}
+
+func TestTools_Idempotency(t *testing.T) {
+	var testTools Tools
+	var runs int32
+	handler := testTools.Idempotency(IdempotencyOptions{Store: &MemoryIdempotencyStore{}}, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&runs, 1)
+		body, _ := io.ReadAll(r.Body)
+		if string(body) == `{"fail":true}` {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		http.SetCookie(w, &http.Cookie{Name: "visit", Value: "1"})
+		w.Header().Set("Location", "/orders/1")
+		w.WriteHeader(http.StatusCreated)
+		_, _ = w.Write([]byte(`{"order":` + string(rune('0'+n)) + `}`))
+	}))
+
+	var idempotencyTests = []struct {
+		name     string
+		method   string
+		key      string
+		body     string
+		status   int
+		response string
+		replayed bool
+		runs     int32
+	}{
+		{name: "first request", method: "POST", key: "a", body: `{"item":1}`, status: http.StatusCreated, response: `{"order":1}`, runs: 1},
+		{name: "retry", method: "POST", key: "a", body: `{"item":1}`, status: http.StatusCreated, response: `{"order":1}`, replayed: true, runs: 1},
+		{name: "different body", method: "POST", key: "a", body: `{"item":2}`, status: http.StatusUnprocessableEntity, runs: 1},
+		{name: "other key", method: "POST", key: "b", body: `{"item":1}`, status: http.StatusCreated, response: `{"order":2}`, runs: 2},
+		{name: "no key", method: "POST", body: `{"item":1}`, status: http.StatusCreated, response: `{"order":3}`, runs: 3},
+		{name: "PUT is not handled", method: "PUT", key: "a", body: `{"item":1}`, status: http.StatusCreated, response: `{"order":4}`, runs: 4},
+		{name: "server error", method: "POST", key: "c", body: `{"fail":true}`, status: http.StatusServiceUnavailable, runs: 5},
+		{name: "server error retried", method: "POST", key: "c", body: `{"fail":true}`, status: http.StatusServiceUnavailable, runs: 6},
+		{name: "key too long", method: "POST", key: strings.Repeat("k", 256), body: `{}`, status: http.StatusBadRequest, runs: 6},
+	}
+	for _, e := range idempotencyTests {
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, idempotentRequest(e.method, e.key, e.body))
+		if rr.Code != e.status {
+			t.Errorf("%s: expected status %d, got %d", e.name, e.status, rr.Code)
+		}
+		if e.response != "" && rr.Body.String() != e.response {
+			t.Errorf("%s: expected body %s, got %s", e.name, e.response, rr.Body.String())
+		}
+		if replayed := rr.Header().Get("Idempotent-Replayed") == "true"; replayed != e.replayed {
+			t.Errorf("%s: expected replayed %v", e.name, e.replayed)
+		}
+		if e.replayed && (rr.Header().Get("Location") != "/orders/1" || rr.Header().Get("Set-Cookie") != "") {
+			t.Errorf("%s: unexpected replayed headers %v", e.name, rr.Header())
+		}
+		if n := atomic.LoadInt32(&runs); n != e.runs {
+			t.Errorf("%s: expected %d handler runs, got %d", e.name, e.runs, n)
+		}
+	}
+}
+
+func TestTools_IdempotencyInFlight(t *testing.T) {
+	var testTools Tools
+	started, release := make(chan struct{}), make(chan struct{})
+	handler := testTools.Idempotency(IdempotencyOptions{}, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+	}))
+
+	done := make(chan int)
+	go func() {
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, idempotentRequest("POST", "a", "{}"))
+		done <- rr.Code
+	}()
+	<-started
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, idempotentRequest("POST", "a", "{}"))
+	if rr.Code != http.StatusConflict || rr.Header().Get("Retry-After") == "" {
+		t.Errorf("expected a 409 with Retry-After, got %d", rr.Code)
+	}
+	close(release)
+	if code := <-done; code != http.StatusOK {
+		t.Errorf("expected the first request to succeed, got %d", code)
+	}
+}
+
+func TestTools_IdempotencyLockTTL(t *testing.T) {
+	var testTools Tools
+	now := time.Now()
+	store := &MemoryIdempotencyStore{now: func() time.Time { return now }}
+	var runs int
+	handler := testTools.Idempotency(IdempotencyOptions{Store: store, LockTTL: time.Second}, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		runs++
+	}))
+
+	// A request that never completed left its key reserved.
+	key := sha256.Sum256([]byte("\na"))
+	fingerprint := sha256.Sum256([]byte("POST /orders\n{}"))
+	_, _ = store.Reserve(context.Background(), hex.EncodeToString(key[:]), &IdempotencyRecord{Fingerprint: hex.EncodeToString(fingerprint[:])}, time.Second)
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, idempotentRequest("POST", "a", "{}"))
+	if rr.Code != http.StatusConflict {
+		t.Errorf("expected the reserved key to be refused, got %d", rr.Code)
+	}
+
+	now = now.Add(2 * time.Second)
+	handler.ServeHTTP(httptest.NewRecorder(), idempotentRequest("POST", "a", "{}"))
+	if runs != 1 {
+		t.Fatalf("expected the lock to expire, got %d runs", runs)
+	}
+	now = now.Add(time.Hour)
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, idempotentRequest("POST", "a", "{}"))
+	if runs != 1 || rr.Header().Get("Idempotent-Replayed") != "true" {
+		t.Errorf("expected the completed response to be kept for TTL, got %d runs", runs)
+	}
+}
+
+func TestTools_IdempotencyOptions(t *testing.T) {
+	var testTools Tools
+	now := time.Now()
+	store := &MemoryIdempotencyStore{now: func() time.Time { return now }}
+	var runs int
+	opts := IdempotencyOptions{
+		Store:    store,
+		TTL:      time.Hour,
+		Required: true,
+		Scope:    func(r *http.Request) string { return r.Header.Get("X-User") },
+	}
+	handler := testTools.Idempotency(opts, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		runs++
+	}))
+	serve := func(user, key string) int {
+		req := idempotentRequest("POST", key, "{}")
+		req.Header.Set("X-User", user)
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr.Code
+	}
+
+	if code := serve("alice", ""); code != http.StatusBadRequest {
+		t.Errorf("expected a missing key to be rejected, got %d", code)
+	}
+	serve("alice", "a")
+	serve("alice", "a")
+	if runs != 1 {
+		t.Errorf("expected the retry to be replayed, got %d runs", runs)
+	}
+	serve("bob", "a")
+	if runs != 2 {
+		t.Errorf("expected keys to be scoped per user, got %d runs", runs)
+	}
+	now = now.Add(2 * time.Hour)
+	serve("alice", "a")
+	if runs != 3 {
+		t.Errorf("expected the key to expire, got %d runs", runs)
+	}
+}
+
+func TestTools_PushJSONToRemoteRetry(t *testing.T) {
+	var pushRetryTests = []struct {
+		name     string
+		policy   *RetryPolicy
+		statuses []int
+		status   int
+		attempts int
+		keyed    bool
+	}{
+		{name: "no policy", statuses: []int{503, 200}, status: 503, attempts: 1},
+		{name: "retried", policy: &RetryPolicy{MaxAttempts: 3}, statuses: []int{503, 429, 200}, status: 200, attempts: 3},
+		{name: "idempotency keys", policy: &RetryPolicy{MaxAttempts: 3, IdempotencyKeys: true}, statuses: []int{502, 200}, status: 200, attempts: 2, keyed: true},
+		{name: "attempts exhausted", policy: &RetryPolicy{MaxAttempts: 2}, statuses: []int{504, 504, 200}, status: 504, attempts: 2},
+		{name: "client error", policy: &RetryPolicy{MaxAttempts: 3}, statuses: []int{400, 200}, status: 400, attempts: 1},
+	}
+	for _, e := range pushRetryTests {
+		var keys []string
+		var bodies []string
+		client := NewTestClient(func(req *http.Request) *http.Response {
+			body, _ := io.ReadAll(req.Body)
+			bodies = append(bodies, string(body))
+			keys = append(keys, req.Header.Get("Idempotency-Key"))
+			return &http.Response{
+				StatusCode: e.statuses[len(keys)-1],
+				Body:       io.NopCloser(bytes.NewBufferString("")),
+				Header:     make(http.Header),
+			}
+		})
+		testTools := Tools{remoteRetry: e.policy}
+		_, status, err := testTools.PushJSONToRemote("http://example.com/", map[string]int{"id": 1}, client)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if status != e.status || len(keys) != e.attempts {
+			t.Errorf("%s: expected status %d after %d attempts, got %d after %d", e.name, e.status, e.attempts, status, len(keys))
+		}
+		for i := range keys {
+			if bodies[i] != `{"id":1}` {
+				t.Errorf("%s: attempt %d sent %q", e.name, i+1, bodies[i])
+			}
+			if (keys[i] != "") != e.keyed || keys[i] != keys[0] {
+				t.Errorf("%s: unexpected idempotency keys %q", e.name, keys)
+			}
+		}
+	}
+}
+
+func TestRetryPolicy_Wait(t *testing.T) {
+	p := &RetryPolicy{Backoff: time.Second, MaxBackoff: 5 * time.Second}
+	for attempt, expected := range []time.Duration{0, time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second, 5 * time.Second} {
+		if attempt == 0 {
+			continue
+		}
+		if got, ok := p.wait(attempt, nil); !ok || got != expected {
+			t.Errorf("attempt %d: expected %s, got %s", attempt, expected, got)
+		}
+	}
+	var retryAfterTests = []struct {
+		maxBackoff time.Duration
+		retryAfter string
+		wait       time.Duration
+		ok         bool
+	}{
+		{maxBackoff: 5 * time.Second, retryAfter: "3", wait: 3 * time.Second, ok: true},
+		{maxBackoff: 5 * time.Second, retryAfter: "30"},
+		{retryAfter: "30", wait: 30 * time.Second, ok: true},
+		{retryAfter: "86400"},
+	}
+	for _, e := range retryAfterTests {
+		p := &RetryPolicy{Backoff: time.Second, MaxBackoff: e.maxBackoff}
+		res := &http.Response{Header: http.Header{"Retry-After": []string{e.retryAfter}}}
+		if got, ok := p.wait(1, res); got != e.wait || ok != e.ok {
+			t.Errorf("Retry-After %s with MaxBackoff %s: expected %s %v, got %s %v", e.retryAfter, e.maxBackoff, e.wait, e.ok, got, ok)
+		}
+	}
+
+	attempts := 0
+	testTools := Tools{remoteRetry: &RetryPolicy{MaxAttempts: 3}}
+	client := NewTestClient(func(req *http.Request) *http.Response {
+		attempts++
+		return &http.Response{StatusCode: http.StatusServiceUnavailable, Body: io.NopCloser(bytes.NewBufferString("")), Header: http.Header{"Retry-After": []string{"86400"}}}
+	})
+	if _, status, err := testTools.PushJSONToRemote("http://example.com/", struct{}{}, client); err != nil || status != http.StatusServiceUnavailable || attempts != 1 {
+		t.Errorf("expected a long Retry-After to end the retries, got %d after %d attempts, %v", status, attempts, err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	testTools = Tools{remoteRetry: &RetryPolicy{MaxAttempts: 3, Backoff: time.Hour}}
+	client = NewTestClient(func(req *http.Request) *http.Response {
+		time.AfterFunc(10*time.Millisecond, cancel)
+		return &http.Response{StatusCode: http.StatusServiceUnavailable, Body: io.NopCloser(bytes.NewBufferString("")), Header: make(http.Header)}
+	})
+	if _, _, err := testTools.PushJSONToRemoteContext(ctx, "http://example.com/", struct{}{}, client); err == nil {
+		t.Error("expected the canceled context to stop the retries")
+	}
+}
@@ -0,0 +1,453 @@
+package toolkit
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/big"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	JWTHS256 = "HS256"
+	JWTES256 = "ES256"
+	JWTEdDSA = "EdDSA"
+)
+
+var (
+	ErrJWTInvalid     = errors.New("Token is invalid")
+	ErrJWTExpired     = errors.New("Token has expired")
+	ErrJWTNotYetValid = errors.New("Token is not valid yet")
+)
+
+// JWTKey is a key of a JWTSigner. HS256 keys use Secret; ES256 keys use an
+// *ecdsa.PrivateKey on the P-256 curve and EdDSA keys an ed25519.PrivateKey.
+// Keys used only to verify tokens may have just a PublicKey.
+type JWTKey struct {
+	Algorithm  string
+	Secret     []byte
+	PrivateKey crypto.PrivateKey
+	PublicKey  crypto.PublicKey
+}
+
+// JWTSigner issues and verifies JSON Web Tokens. Tokens are signed with
+// CurrentKey and carry its ID in their kid header; tokens signed with any
+// other key still in Keys keep verifying, which allows keys to be rotated.
+// Verification checks exp and nbf allowing for ClockSkew, and iss and aud when
+// Issuer and Audience are set. Sign fills in Issuer, and an expiry TTL after
+// the time of issue when TTL is set and the claims have none.
+type JWTSigner struct {
+	Keys       map[string]JWTKey
+	CurrentKey string
+	Issuer     string
+	Audience   []string
+	ClockSkew  time.Duration
+	TTL        time.Duration
+
+	now func() time.Time
+}
+
+// JWTClaims holds the registered claims of a token, times being Unix seconds,
+// and any other claims in Custom.
+type JWTClaims struct {
+	Issuer    string                 `json:"iss,omitempty"`
+	Subject   string                 `json:"sub,omitempty"`
+	Audience  Audience               `json:"aud,omitempty"`
+	ExpiresAt int64                  `json:"exp,omitempty"`
+	NotBefore int64                  `json:"nbf,omitempty"`
+	IssuedAt  int64                  `json:"iat,omitempty"`
+	ID        string                 `json:"jti,omitempty"`
+	Custom    map[string]interface{} `json:"-"`
+}
+
+type registeredClaims JWTClaims
+
+var registeredClaimNames = []string{"iss", "sub", "aud", "exp", "nbf", "iat", "jti"}
+
+func (c JWTClaims) MarshalJSON() ([]byte, error) {
+	registered, err := json.Marshal(registeredClaims(c))
+	if err != nil || len(c.Custom) == 0 {
+		return registered, err
+	}
+	merged := make(map[string]json.RawMessage)
+	for name, value := range c.Custom {
+		raw, err := json.Marshal(value)
+		if err != nil {
+			return nil, err
+		}
+		merged[name] = raw
+	}
+	if err = json.Unmarshal(registered, &merged); err != nil {
+		return nil, err
+	}
+	return json.Marshal(merged)
+}
+
+func (c *JWTClaims) UnmarshalJSON(data []byte) error {
+	if err := json.Unmarshal(data, (*registeredClaims)(c)); err != nil {
+		return err
+	}
+	var all map[string]interface{}
+	if err := json.Unmarshal(data, &all); err != nil {
+		return err
+	}
+	for _, name := range registeredClaimNames {
+		delete(all, name)
+	}
+	c.Custom = nil
+	if len(all) > 0 {
+		c.Custom = all
+	}
+	return nil
+}
+
+// Audience is the aud claim, which is either a string or an array of strings.
+type Audience []string
+
+func (a Audience) MarshalJSON() ([]byte, error) {
+	if len(a) == 1 {
+		return json.Marshal(a[0])
+	}
+	return json.Marshal([]string(a))
+}
+
+func (a *Audience) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*a = Audience{single}
+		return nil
+	}
+	var list []string
+	if err := json.Unmarshal(data, &list); err != nil {
+		return err
+	}
+	*a = list
+	return nil
+}
+
+type jwtHeader struct {
+	Algorithm string `json:"alg"`
+	Type      string `json:"typ,omitempty"`
+	KeyID     string `json:"kid,omitempty"`
+}
+
+func (s *JWTSigner) clock() time.Time {
+	if s.now != nil {
+		return s.now()
+	}
+	return time.Now()
+}
+
+func (s *JWTSigner) Sign(claims JWTClaims) (string, error) {
+	key, ok := s.Keys[s.CurrentKey]
+	if !ok {
+		return "", errors.New("Current signing key is not in the key set")
+	}
+	now := s.clock()
+	if claims.IssuedAt == 0 {
+		claims.IssuedAt = now.Unix()
+	}
+	if claims.ExpiresAt == 0 && s.TTL > 0 {
+		claims.ExpiresAt = time.Unix(claims.IssuedAt, 0).Add(s.TTL).Unix()
+	}
+	if claims.Issuer == "" {
+		claims.Issuer = s.Issuer
+	}
+
+	header, err := json.Marshal(jwtHeader{Algorithm: key.Algorithm, Type: "JWT", KeyID: s.CurrentKey})
+	if err != nil {
+		return "", err
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	input := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	signature, err := key.sign([]byte(input))
+	if err != nil {
+		return "", err
+	}
+	return input + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
+
+// Verify checks the signature and the claims of token. The algorithm named in
+// the token must be the one of its key, so a token cannot pick a weaker one.
+func (s *JWTSigner) Verify(token string) (*JWTClaims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, ErrJWTInvalid
+	}
+	var header jwtHeader
+	if err := decodeJWTPart(parts[0], &header); err != nil {
+		return nil, ErrJWTInvalid
+	}
+	key, ok := s.Keys[header.KeyID]
+	if !ok && header.KeyID == "" && len(s.Keys) == 1 {
+		for _, only := range s.Keys {
+			key, ok = only, true
+		}
+	}
+	if !ok || header.Algorithm != key.Algorithm {
+		return nil, ErrJWTInvalid
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil || !key.verify([]byte(parts[0]+"."+parts[1]), signature) {
+		return nil, ErrJWTInvalid
+	}
+	var claims JWTClaims
+	if err = decodeJWTPart(parts[1], &claims); err != nil {
+		return nil, ErrJWTInvalid
+	}
+	if err = s.validate(&claims); err != nil {
+		return nil, err
+	}
+	return &claims, nil
+}
+
+func (s *JWTSigner) validate(claims *JWTClaims) error {
+	now := s.clock()
+	if claims.ExpiresAt != 0 && now.Add(-s.ClockSkew).Unix() >= claims.ExpiresAt {
+		return ErrJWTExpired
+	}
+	if claims.NotBefore != 0 && now.Add(s.ClockSkew).Unix() < claims.NotBefore {
+		return ErrJWTNotYetValid
+	}
+	if s.Issuer != "" && claims.Issuer != s.Issuer {
+		return ErrJWTInvalid
+	}
+	if len(s.Audience) > 0 {
+		for _, aud := range claims.Audience {
+			for _, expected := range s.Audience {
+				if aud == expected {
+					return nil
+				}
+			}
+		}
+		return ErrJWTInvalid
+	}
+	return nil
+}
+
+func decodeJWTPart(part string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(part)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+func (k JWTKey) sign(input []byte) ([]byte, error) {
+	switch k.Algorithm {
+	case JWTHS256:
+		if len(k.Secret) == 0 {
+			return nil, errors.New("HS256 key has no secret")
+		}
+		mac := hmac.New(sha256.New, k.Secret)
+		mac.Write(input)
+		return mac.Sum(nil), nil
+	case JWTES256:
+		priv, ok := k.PrivateKey.(*ecdsa.PrivateKey)
+		if !ok || priv.Curve != elliptic.P256() {
+			return nil, errors.New("ES256 key needs a P-256 *ecdsa.PrivateKey")
+		}
+		digest := sha256.Sum256(input)
+		r, s, err := ecdsa.Sign(rand.Reader, priv, digest[:])
+		if err != nil {
+			return nil, err
+		}
+		signature := make([]byte, 64)
+		r.FillBytes(signature[:32])
+		s.FillBytes(signature[32:])
+		return signature, nil
+	case JWTEdDSA:
+		priv, ok := k.PrivateKey.(ed25519.PrivateKey)
+		if !ok || len(priv) != ed25519.PrivateKeySize {
+			return nil, errors.New("EdDSA key needs an ed25519.PrivateKey")
+		}
+		return ed25519.Sign(priv, input), nil
+	default:
+		return nil, fmt.Errorf("Unsupported JWT algorithm %q", k.Algorithm)
+	}
+}
+
+func (k JWTKey) verify(input, signature []byte) bool {
+	switch k.Algorithm {
+	case JWTHS256:
+		if len(k.Secret) == 0 {
+			return false
+		}
+		mac := hmac.New(sha256.New, k.Secret)
+		mac.Write(input)
+		return hmac.Equal(signature, mac.Sum(nil))
+	case JWTES256:
+		pub, ok := k.publicKey().(*ecdsa.PublicKey)
+		if !ok || pub.Curve != elliptic.P256() || len(signature) != 64 {
+			return false
+		}
+		digest := sha256.Sum256(input)
+		r := new(big.Int).SetBytes(signature[:32])
+		s := new(big.Int).SetBytes(signature[32:])
+		return ecdsa.Verify(pub, digest[:], r, s)
+	case JWTEdDSA:
+		pub, ok := k.publicKey().(ed25519.PublicKey)
+		if !ok || len(pub) != ed25519.PublicKeySize {
+			return false
+		}
+		return ed25519.Verify(pub, input, signature)
+	}
+	return false
+}
+
+func (k JWTKey) publicKey() crypto.PublicKey {
+	if k.PublicKey != nil {
+		return k.PublicKey
+	}
+	if signer, ok := k.PrivateKey.(crypto.Signer); ok {
+		return signer.Public()
+	}
+	return nil
+}
+
+type jwk struct {
+	KeyType   string `json:"kty"`
+	KeyID     string `json:"kid,omitempty"`
+	Algorithm string `json:"alg,omitempty"`
+	Use       string `json:"use,omitempty"`
+	Curve     string `json:"crv,omitempty"`
+	X         string `json:"x,omitempty"`
+	Y         string `json:"y,omitempty"`
+	K         string `json:"k,omitempty"`
+}
+
+type jwks struct {
+	Keys []jwk `json:"keys"`
+}
+
+// JWKS returns the public keys of s as a JSON Web Key Set that other services
+// can verify tokens with. HS256 keys are secret and left out.
+func (s *JWTSigner) JWKS() ([]byte, error) {
+	set := jwks{Keys: []jwk{}}
+	for _, kid := range sortedKeys(s.Keys) {
+		key := s.Keys[kid]
+		entry := jwk{KeyID: kid, Algorithm: key.Algorithm, Use: "sig"}
+		switch pub := key.publicKey().(type) {
+		case *ecdsa.PublicKey:
+			if pub.Curve != elliptic.P256() {
+				continue
+			}
+			point, err := pub.ECDH()
+			if err != nil {
+				return nil, err
+			}
+			raw := point.Bytes()
+			entry.KeyType, entry.Curve = "EC", "P-256"
+			entry.X = base64.RawURLEncoding.EncodeToString(raw[1:33])
+			entry.Y = base64.RawURLEncoding.EncodeToString(raw[33:])
+		case ed25519.PublicKey:
+			entry.KeyType, entry.Curve = "OKP", "Ed25519"
+			entry.X = base64.RawURLEncoding.EncodeToString(pub)
+		default:
+			continue
+		}
+		set.Keys = append(set.Keys, entry)
+	}
+	return json.Marshal(set)
+}
+
+// ParseJWKS reads the keys of a JSON Web Key Set, for use as the Keys of a
+// JWTSigner that verifies tokens. EC P-256, Ed25519 and symmetric keys are
+// supported.
+func ParseJWKS(data []byte) (map[string]JWTKey, error) {
+	var set jwks
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, err
+	}
+	keys := make(map[string]JWTKey)
+	for _, entry := range set.Keys {
+		key, err := entry.jwtKey()
+		if err != nil {
+			return nil, fmt.Errorf("Key %q: %w", entry.KeyID, err)
+		}
+		keys[entry.KeyID] = key
+	}
+	return keys, nil
+}
+
+func (j jwk) jwtKey() (JWTKey, error) {
+	switch {
+	case j.KeyType == "EC" && j.Curve == "P-256":
+		x, errX := base64.RawURLEncoding.DecodeString(j.X)
+		y, errY := base64.RawURLEncoding.DecodeString(j.Y)
+		if errX != nil || errY != nil || len(x) != 32 || len(y) != 32 {
+			return JWTKey{}, errors.New("Invalid EC coordinates")
+		}
+		point := append(append([]byte{4}, x...), y...)
+		if _, err := ecdh.P256().NewPublicKey(point); err != nil {
+			return JWTKey{}, errors.New("Point is not on the P-256 curve")
+		}
+		pub := &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		return JWTKey{Algorithm: JWTES256, PublicKey: pub}, nil
+	case j.KeyType == "OKP" && j.Curve == "Ed25519":
+		x, err := base64.RawURLEncoding.DecodeString(j.X)
+		if err != nil || len(x) != ed25519.PublicKeySize {
+			return JWTKey{}, errors.New("Invalid Ed25519 key")
+		}
+		return JWTKey{Algorithm: JWTEdDSA, PublicKey: ed25519.PublicKey(x)}, nil
+	case j.KeyType == "oct":
+		k, err := base64.RawURLEncoding.DecodeString(j.K)
+		if err != nil || len(k) == 0 {
+			return JWTKey{}, errors.New("Invalid symmetric key")
+		}
+		return JWTKey{Algorithm: JWTHS256, Secret: k}, nil
+	}
+	return JWTKey{}, fmt.Errorf("Unsupported key type %q", j.KeyType)
+}
+
+type jwtClaimsKey struct{}
+
+func ContextWithJWTClaims(ctx context.Context, claims *JWTClaims) context.Context {
+	return context.WithValue(ctx, jwtClaimsKey{}, claims)
+}
+
+func JWTClaimsFromContext(ctx context.Context) (*JWTClaims, bool) {
+	claims, ok := ctx.Value(jwtClaimsKey{}).(*JWTClaims)
+	return claims, ok
+}
+
+// RequireJWT only lets requests with a valid bearer token reach next, with the
+// claims of the token in the request context. Other requests get a 401.
+func (t *Tools) RequireJWT(signer *JWTSigner, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		scheme, token, _ := strings.Cut(r.Header.Get("Authorization"), " ")
+		if !strings.EqualFold(scheme, "Bearer") || token == "" {
+			w.Header().Set("WWW-Authenticate", `Bearer`)
+			_ = t.ErrorJSON(w, errors.New("Authorization required"), http.StatusUnauthorized)
+			return
+		}
+		claims, err := signer.Verify(strings.TrimSpace(token))
+		if err != nil {
+			t.log(r.Context(), slog.LevelInfo, "token rejected",
+				slog.String("path", r.URL.Path),
+				slog.String("reason", err.Error()))
+			w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+			_ = t.ErrorJSON(w, err, http.StatusUnauthorized)
+			return
+		}
+		ctx := ContextWithJWTClaims(r.Context(), claims)
+		ctx = ContextWithLogAttrs(ctx, slog.String("subject", claims.Subject))
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
@@ -0,0 +1,208 @@
+package toolkit
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testJWTKeys(t *testing.T) map[string]JWTKey {
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return map[string]JWTKey{
+		"hs":    {Algorithm: JWTHS256, Secret: []byte("0123456789abcdef0123456789abcdef")},
+		"es":    {Algorithm: JWTES256, PrivateKey: ecKey},
+		"eddsa": {Algorithm: JWTEdDSA, PrivateKey: edKey},
+	}
+}
+
+func TestJWTSigner_SignVerify(t *testing.T) {
+	keys := testJWTKeys(t)
+	for kid := range keys {
+		signer := &JWTSigner{Keys: keys, CurrentKey: kid, Issuer: "toolkit", Audience: []string{"api"}, TTL: time.Minute}
+		token, err := signer.Sign(JWTClaims{
+			Subject:  "user-1",
+			Audience: Audience{"api"},
+			Custom:   map[string]interface{}{"role": "admin"},
+		})
+		if err != nil {
+			t.Fatalf("%s: %v", kid, err)
+		}
+		claims, err := signer.Verify(token)
+		if err != nil {
+			t.Fatalf("%s: %v", kid, err)
+		}
+		if claims.Subject != "user-1" || claims.Issuer != "toolkit" || claims.Custom["role"] != "admin" {
+			t.Errorf("%s: unexpected claims %+v", kid, claims)
+		}
+		if claims.ExpiresAt-claims.IssuedAt != 60 {
+			t.Errorf("%s: expected a one minute TTL, got %+v", kid, claims)
+		}
+	}
+}
+
+func TestJWTSigner_KnownHS256Token(t *testing.T) {
+	// Example token of RFC 7515, appendix A.1.
+	secret, _ := base64.RawURLEncoding.DecodeString("AyM1SysPpbyDfgZld3umj1qzKObwVMkoqQ-EstJQLr_T-1qS0gZH75aKtMN3Yj0iPS4hcgUuTwjAzZr1Z9CAow")
+	token := "eyJ0eXAiOiJKV1QiLA0KICJhbGciOiJIUzI1NiJ9" +
+		".eyJpc3MiOiJqb2UiLA0KICJleHAiOjEzMDA4MTkzODAsDQogImh0dHA6Ly9leGFtcGxlLmNvbS9pc19yb290Ijp0cnVlfQ" +
+		".dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"
+	signer := &JWTSigner{
+		Keys: map[string]JWTKey{"only": {Algorithm: JWTHS256, Secret: secret}},
+		now:  func() time.Time { return time.Unix(1300819000, 0) },
+	}
+	claims, err := signer.Verify(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims.Issuer != "joe" || claims.Custom["http://example.com/is_root"] != true {
+		t.Errorf("unexpected claims %+v", claims)
+	}
+}
+
+func TestJWTSigner_Validation(t *testing.T) {
+	keys := testJWTKeys(t)
+	now := time.Unix(1700000000, 0)
+	signer := &JWTSigner{
+		Keys:       keys,
+		CurrentKey: "hs",
+		Issuer:     "toolkit",
+		Audience:   []string{"api"},
+		ClockSkew:  30 * time.Second,
+		now:        func() time.Time { return now },
+	}
+	var validationTests = []struct {
+		name     string
+		claims   JWTClaims
+		expected error
+	}{
+		{name: "valid", claims: JWTClaims{Audience: Audience{"other", "api"}, ExpiresAt: now.Unix() + 10}},
+		{name: "expired within skew", claims: JWTClaims{Audience: Audience{"api"}, ExpiresAt: now.Unix() - 20}},
+		{name: "expired", claims: JWTClaims{Audience: Audience{"api"}, ExpiresAt: now.Unix() - 31}, expected: ErrJWTExpired},
+		{name: "not yet valid within skew", claims: JWTClaims{Audience: Audience{"api"}, NotBefore: now.Unix() + 20}},
+		{name: "not yet valid", claims: JWTClaims{Audience: Audience{"api"}, NotBefore: now.Unix() + 60}, expected: ErrJWTNotYetValid},
+		{name: "wrong audience", claims: JWTClaims{Audience: Audience{"web"}}, expected: ErrJWTInvalid},
+		{name: "wrong issuer", claims: JWTClaims{Issuer: "evil", Audience: Audience{"api"}}, expected: ErrJWTInvalid},
+	}
+	for _, e := range validationTests {
+		token, err := signer.Sign(e.claims)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = signer.Verify(token); !errors.Is(err, e.expected) {
+			t.Errorf("%s: expected %v, got %v", e.name, e.expected, err)
+		}
+	}
+}
+
+func TestJWTSigner_RejectsTampering(t *testing.T) {
+	keys := testJWTKeys(t)
+	signer := &JWTSigner{Keys: keys, CurrentKey: "es"}
+	token, err := signer.Sign(JWTClaims{Subject: "user-1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	parts := strings.Split(token, ".")
+
+	payload, _ := json.Marshal(JWTClaims{Subject: "admin"})
+	forged := parts[0] + "." + base64.RawURLEncoding.EncodeToString(payload) + "." + parts[2]
+	if _, err = signer.Verify(forged); !errors.Is(err, ErrJWTInvalid) {
+		t.Errorf("forged payload: expected ErrJWTInvalid, got %v", err)
+	}
+
+	none := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"none","kid":"es"}`))
+	if _, err = signer.Verify(none + "." + parts[1] + "."); !errors.Is(err, ErrJWTInvalid) {
+		t.Errorf("alg none: expected ErrJWTInvalid, got %v", err)
+	}
+
+	hs := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"HS256","kid":"es"}`))
+	if _, err = signer.Verify(hs + "." + parts[1] + "." + parts[2]); !errors.Is(err, ErrJWTInvalid) {
+		t.Errorf("algorithm switch: expected ErrJWTInvalid, got %v", err)
+	}
+
+	rotated := &JWTSigner{Keys: map[string]JWTKey{"eddsa": keys["eddsa"]}, CurrentKey: "eddsa"}
+	if _, err = rotated.Verify(token); !errors.Is(err, ErrJWTInvalid) {
+		t.Errorf("removed key: expected ErrJWTInvalid, got %v", err)
+	}
+}
+
+func TestJWTSigner_JWKS(t *testing.T) {
+	keys := testJWTKeys(t)
+	signer := &JWTSigner{Keys: keys, CurrentKey: "es"}
+	data, err := signer.JWKS()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), `"oct"`) {
+		t.Error("symmetric keys must not be published")
+	}
+	published, err := ParseJWKS(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(published) != 2 {
+		t.Fatalf("expected 2 public keys, got %d", len(published))
+	}
+	verifier := &JWTSigner{Keys: published}
+	for _, kid := range []string{"es", "eddsa"} {
+		signer.CurrentKey = kid
+		token, err := signer.Sign(JWTClaims{Subject: "user-1"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = verifier.Verify(token); err != nil {
+			t.Errorf("%s: %v", kid, err)
+		}
+	}
+}
+
+func TestTools_RequireJWT(t *testing.T) {
+	var testTools Tools
+	signer := &JWTSigner{Keys: testJWTKeys(t), CurrentKey: "hs"}
+	var subject string
+	handler := testTools.RequireJWT(signer, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		claims, _ := JWTClaimsFromContext(r.Context())
+		subject = claims.Subject
+	}))
+	token, err := signer.Sign(JWTClaims{Subject: "user-1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, header := range []string{"", "Basic abc", "Bearer " + token + "x"} {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("Authorization", header)
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		if rr.Code != http.StatusUnauthorized || rr.Header().Get("WWW-Authenticate") == "" {
+			t.Errorf("%q: expected 401, got %d", header, rr.Code)
+		}
+		var payload JSONResponse
+		if err = json.NewDecoder(rr.Body).Decode(&payload); err != nil || !payload.Error {
+			t.Errorf("%q: expected an error body, got %+v", header, payload)
+		}
+	}
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK || subject != "user-1" {
+		t.Errorf("expected the claims to reach the handler, got %d %q", rr.Code, subject)
+	}
+}
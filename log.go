@@ -0,0 +1,39 @@
+package toolkit
+
+import (
+	"context"
+	"log/slog"
+)
+
+type logAttrsKey struct{}
+
+// ContextWithLogAttrs returns a copy of ctx carrying attrs, which are added to
+// every event Tools logs while handling a request with that context. A
+// middleware can use it to tag events with a request or user ID.
+func ContextWithLogAttrs(ctx context.Context, attrs ...slog.Attr) context.Context {
+	existing := logAttrsFromContext(ctx)
+	merged := make([]slog.Attr, 0, len(existing)+len(attrs))
+	merged = append(merged, existing...)
+	merged = append(merged, attrs...)
+	return context.WithValue(ctx, logAttrsKey{}, merged)
+}
+
+func logAttrsFromContext(ctx context.Context) []slog.Attr {
+	attrs, _ := ctx.Value(logAttrsKey{}).([]slog.Attr)
+	return attrs
+}
+
+// log emits an event on the Logger of t, if there is one, with the attributes
+// of ctx before attrs.
+func (t *Tools) log(ctx context.Context, level slog.Level, msg string, attrs ...slog.Attr) {
+	if t.logger == nil {
+		return
+	}
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	if !t.logger.Enabled(ctx, level) {
+		return
+	}
+	t.logger.LogAttrs(ctx, level, msg, append(logAttrsFromContext(ctx), attrs...)...)
+}
@@ -0,0 +1,145 @@
+package toolkit
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+func newTestLogger(buf *bytes.Buffer) *slog.Logger {
+	return slog.New(slog.NewJSONHandler(buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+}
+
+func logEvents(t *testing.T, buf *bytes.Buffer) []map[string]interface{} {
+	var events []map[string]interface{}
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		if line == "" {
+			continue
+		}
+		event := make(map[string]interface{})
+		if err := json.Unmarshal([]byte(line), &event); err != nil {
+			t.Fatal(err)
+		}
+		events = append(events, event)
+	}
+	return events
+}
+
+var uploadLogTests = []struct {
+	name         string
+	allowedTypes []string
+	level        string
+	msg          string
+	reason       string
+}{
+	{name: "accepted", allowedTypes: []string{"image/png"}, level: "INFO", msg: "upload accepted"},
+	{name: "rejected", allowedTypes: []string{"image/jpeg"}, level: "INFO", msg: "upload rejected", reason: "type_not_allowed"},
+}
+
+func TestTools_LogUpload(t *testing.T) {
+	png, err := os.ReadFile("./testdata/img.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range uploadLogTests {
+		uploadDir := t.TempDir()
+		var buf bytes.Buffer
+		testTools := Tools{allowedTypes: e.allowedTypes, logger: newTestLogger(&buf)}
+
+		request := newMultipartRequest(t, map[string][]byte{"img.png": png}, "img.png")
+		request = request.WithContext(ContextWithLogAttrs(request.Context(), slog.String("request_id", "abc")))
+		_, _ = testTools.UploadFiles(request, uploadDir)
+
+		events := logEvents(t, &buf)
+		if len(events) != 1 {
+			t.Fatalf("%s: expected 1 event, got %d", e.name, len(events))
+		}
+		event := events[0]
+		if event["level"] != e.level || event["msg"] != e.msg {
+			t.Errorf("%s: unexpected event %v", e.name, event)
+		}
+		if event["file"] != "img.png" || event["content_type"] != "image/png" || event["request_id"] != "abc" {
+			t.Errorf("%s: missing attributes in %v", e.name, event)
+		}
+		if size, _ := event["size"].(float64); e.reason == "" && int(size) != len(png) {
+			t.Errorf("%s: expected size %d, got %v", e.name, len(png), event["size"])
+		}
+		if e.reason != "" && event["reason"] != e.reason {
+			t.Errorf("%s: expected reason %q, got %v", e.name, e.reason, event["reason"])
+		}
+	}
+}
+
+func TestTools_LogReadJSON(t *testing.T) {
+	var buf bytes.Buffer
+	testTools := Tools{logger: newTestLogger(&buf)}
+
+	req := httptest.NewRequest("POST", "/items", strings.NewReader(`{"foo":`))
+	req = req.WithContext(ContextWithLogAttrs(req.Context(), slog.String("user", "42")))
+	var decoded struct {
+		Foo string `json:"foo"`
+	}
+	if err := testTools.ReadJSON(httptest.NewRecorder(), *req, &decoded); err == nil {
+		t.Fatal("expected an error")
+	}
+
+	events := logEvents(t, &buf)
+	if len(events) != 1 {
+		t.Fatalf("expected 1 event, got %d", len(events))
+	}
+	event := events[0]
+	if event["msg"] != "JSON decode failed" || event["path"] != "/items" || event["user"] != "42" {
+		t.Errorf("unexpected event %v", event)
+	}
+	if reason, _ := event["reason"].(string); !strings.Contains(reason, "badly-formed") {
+		t.Errorf("unexpected reason %q", reason)
+	}
+}
+
+var remoteLogTests = []struct {
+	name   string
+	status int
+	failed bool
+	level  string
+	msg    string
+}{
+	{name: "ok", status: http.StatusOK, level: "INFO", msg: "remote call completed"},
+	{name: "server error", status: http.StatusBadGateway, level: "WARN", msg: "remote call completed"},
+	{name: "transport error", failed: true, level: "ERROR", msg: "remote call failed"},
+}
+
+func TestTools_LogPushJSONToRemote(t *testing.T) {
+	for _, e := range remoteLogTests {
+		var buf bytes.Buffer
+		testTools := Tools{logger: newTestLogger(&buf)}
+		client := NewTestClientWithError(func(req *http.Request) (*http.Response, error) {
+			if e.failed {
+				return nil, errors.New("connection refused")
+			}
+			return &http.Response{StatusCode: e.status, Body: http.NoBody, Header: make(http.Header)}, nil
+		})
+
+		_, _, _ = testTools.PushJSONToRemote("http://example.com/hook?token=secret", struct{}{}, client)
+
+		events := logEvents(t, &buf)
+		if len(events) != 1 {
+			t.Fatalf("%s: expected 1 event, got %d", e.name, len(events))
+		}
+		event := events[0]
+		if event["level"] != e.level || event["msg"] != e.msg {
+			t.Errorf("%s: unexpected event %v", e.name, event)
+		}
+		if event["url"] != "http://example.com/hook" {
+			t.Errorf("%s: unexpected url %v", e.name, event["url"])
+		}
+		if _, ok := event["latency"]; !ok {
+			t.Errorf("%s: latency missing", e.name)
+		}
+	}
+}
@@ -0,0 +1,266 @@
+package toolkit
+
+import (
+	"bufio"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Metrics receives the counters and histogram observations recorded by Tools.
+// Implementations must be safe for concurrent use.
+type Metrics interface {
+	Add(name string, value float64, labels ...Label)
+	Observe(name string, value float64, labels ...Label)
+}
+
+type Label struct {
+	Name  string
+	Value string
+}
+
+// Names of the metrics recorded by Tools.
+const (
+	MetricUploads            = "toolkit_uploads_total"
+	MetricUploadBytes        = "toolkit_upload_bytes_total"
+	MetricUploadSize         = "toolkit_upload_size_bytes"
+	MetricUploadRejections   = "toolkit_upload_rejections_total"
+	MetricJSONResponses      = "toolkit_json_responses_total"
+	MetricRemotePushAttempts = "toolkit_remote_push_attempts_total"
+	MetricRemotePushFailures = "toolkit_remote_push_failures_total"
+	MetricRemotePushDuration = "toolkit_remote_push_duration_seconds"
+)
+
+var metricHelp = map[string]string{
+	MetricUploads:            "Uploaded files accepted.",
+	MetricUploadBytes:        "Bytes of uploaded files accepted.",
+	MetricUploadSize:         "Size of uploaded files accepted.",
+	MetricUploadRejections:   "Uploaded files rejected, by reason.",
+	MetricJSONResponses:      "JSON responses written, by status code.",
+	MetricRemotePushAttempts: "Requests sent to remote services.",
+	MetricRemotePushFailures: "Requests to remote services that failed or returned an error status.",
+	MetricRemotePushDuration: "Latency of requests to remote services.",
+}
+
+// NopMetrics discards everything. It is used when no Metrics is configured.
+type NopMetrics struct{}
+
+func (NopMetrics) Add(name string, value float64, labels ...Label)     {}
+func (NopMetrics) Observe(name string, value float64, labels ...Label) {}
+
+// MemoryMetrics keeps counter totals and every observation in memory, which is
+// mostly useful in tests.
+type MemoryMetrics struct {
+	mu           sync.Mutex
+	counters     map[string]float64
+	observations map[string][]float64
+}
+
+func (m *MemoryMetrics) Add(name string, value float64, labels ...Label) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.counters == nil {
+		m.counters = make(map[string]float64)
+	}
+	m.counters[name+formatLabels(labels)] += value
+}
+
+func (m *MemoryMetrics) Observe(name string, value float64, labels ...Label) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.observations == nil {
+		m.observations = make(map[string][]float64)
+	}
+	key := name + formatLabels(labels)
+	m.observations[key] = append(m.observations[key], value)
+}
+
+// Counter returns the total of the counter with exactly these labels.
+func (m *MemoryMetrics) Counter(name string, labels ...Label) float64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.counters[name+formatLabels(labels)]
+}
+
+// Observations returns the values observed for the histogram with exactly
+// these labels, in order.
+func (m *MemoryMetrics) Observations(name string, labels ...Label) []float64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]float64(nil), m.observations[name+formatLabels(labels)]...)
+}
+
+// PrometheusMetrics aggregates counters and histograms and serves them in the
+// Prometheus text exposition format. Buckets sets the upper bounds of the
+// buckets of a histogram; histograms not listed use byte sized buckets when
+// their name ends in "_bytes" and latency buckets in seconds otherwise.
+type PrometheusMetrics struct {
+	Buckets map[string][]float64
+
+	mu         sync.Mutex
+	counters   map[string]map[string]float64
+	histograms map[string]map[string]*histogram
+}
+
+type histogram struct {
+	bounds []float64
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+var (
+	defaultSecondsBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+	defaultBytesBuckets   = []float64{1 << 10, 1 << 14, 1 << 17, 1 << 20, 1 << 23, 1 << 26, 1 << 30}
+)
+
+func (p *PrometheusMetrics) Add(name string, value float64, labels ...Label) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.counters == nil {
+		p.counters = make(map[string]map[string]float64)
+	}
+	series, ok := p.counters[name]
+	if !ok {
+		series = make(map[string]float64)
+		p.counters[name] = series
+	}
+	series[formatLabels(labels)] += value
+}
+
+func (p *PrometheusMetrics) Observe(name string, value float64, labels ...Label) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.histograms == nil {
+		p.histograms = make(map[string]map[string]*histogram)
+	}
+	series, ok := p.histograms[name]
+	if !ok {
+		series = make(map[string]*histogram)
+		p.histograms[name] = series
+	}
+	key := formatLabels(labels)
+	h, ok := series[key]
+	if !ok {
+		bounds := p.bucketsFor(name)
+		h = &histogram{bounds: bounds, counts: make([]uint64, len(bounds))}
+		series[key] = h
+	}
+	for i, bound := range h.bounds {
+		if value <= bound {
+			h.counts[i]++
+		}
+	}
+	h.count++
+	h.sum += value
+}
+
+func (p *PrometheusMetrics) bucketsFor(name string) []float64 {
+	if bounds, ok := p.Buckets[name]; ok {
+		bounds = append([]float64(nil), bounds...)
+		sort.Float64s(bounds)
+		return bounds
+	}
+	if strings.HasSuffix(name, "_bytes") {
+		return defaultBytesBuckets
+	}
+	return defaultSecondsBuckets
+}
+
+func (p *PrometheusMetrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	out := bufio.NewWriter(w)
+	defer out.Flush()
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, name := range sortedKeys(p.counters) {
+		writeMetricHeader(out, name, "counter")
+		series := p.counters[name]
+		for _, labels := range sortedKeys(series) {
+			fmt.Fprintf(out, "%s%s %s\n", name, labels, formatMetricValue(series[labels]))
+		}
+	}
+	for _, name := range sortedKeys(p.histograms) {
+		writeMetricHeader(out, name, "histogram")
+		series := p.histograms[name]
+		for _, labels := range sortedKeys(series) {
+			h := series[labels]
+			for i, bound := range h.bounds {
+				fmt.Fprintf(out, "%s_bucket%s %d\n", name, withLabel(labels, "le", formatMetricValue(bound)), h.counts[i])
+			}
+			fmt.Fprintf(out, "%s_bucket%s %d\n", name, withLabel(labels, "le", "+Inf"), h.count)
+			fmt.Fprintf(out, "%s_sum%s %s\n", name, labels, formatMetricValue(h.sum))
+			fmt.Fprintf(out, "%s_count%s %d\n", name, labels, h.count)
+		}
+	}
+}
+
+func writeMetricHeader(out *bufio.Writer, name, kind string) {
+	if help, ok := metricHelp[name]; ok {
+		fmt.Fprintf(out, "# HELP %s %s\n", name, help)
+	}
+	fmt.Fprintf(out, "# TYPE %s %s\n", name, kind)
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// formatLabels renders labels sorted by name, as in {a="1",b="2"}, or returns
+// "" when there are none.
+func formatLabels(labels []Label) string {
+	if len(labels) == 0 {
+		return ""
+	}
+	sorted := append([]Label(nil), labels...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Name < sorted[j].Name })
+	var b strings.Builder
+	b.WriteByte('{')
+	for i, label := range sorted {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(label.Name)
+		b.WriteString(`="`)
+		b.WriteString(escapeLabelValue(label.Value))
+		b.WriteByte('"')
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
+func withLabel(labels, name, value string) string {
+	label := name + `="` + escapeLabelValue(value) + `"`
+	if labels == "" {
+		return "{" + label + "}"
+	}
+	return labels[:len(labels)-1] + "," + label + "}"
+}
+
+var labelValueEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabelValue(value string) string {
+	return labelValueEscaper.Replace(value)
+}
+
+func formatMetricValue(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
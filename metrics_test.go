@@ -0,0 +1,116 @@
+package toolkit
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestPrometheusMetrics_ServeHTTP(t *testing.T) {
+	p := &PrometheusMetrics{Buckets: map[string][]float64{"latency_seconds": {1, 0.5}}}
+	p.Add(MetricUploadRejections, 1, Label{Name: "reason", Value: "infected"})
+	p.Add(MetricUploadRejections, 2, Label{Name: "reason", Value: `quota "exceeded"`})
+	p.Add("plain_total", 1.5)
+	p.Observe("latency_seconds", 0.25, Label{Name: "route", Value: "a"})
+	p.Observe("latency_seconds", 0.75, Label{Name: "route", Value: "a"})
+	p.Observe("latency_seconds", 3, Label{Name: "route", Value: "a"})
+
+	rr := httptest.NewRecorder()
+	p.ServeHTTP(rr, httptest.NewRequest("GET", "/metrics", nil))
+
+	expected := `# TYPE plain_total counter
+plain_total 1.5
+# HELP toolkit_upload_rejections_total Uploaded files rejected, by reason.
+# TYPE toolkit_upload_rejections_total counter
+toolkit_upload_rejections_total{reason="infected"} 1
+toolkit_upload_rejections_total{reason="quota \"exceeded\""} 2
+# TYPE latency_seconds histogram
+latency_seconds_bucket{route="a",le="0.5"} 1
+latency_seconds_bucket{route="a",le="1"} 2
+latency_seconds_bucket{route="a",le="+Inf"} 3
+latency_seconds_sum{route="a"} 4
+latency_seconds_count{route="a"} 3
+`
+	if rr.Body.String() != expected {
+		t.Errorf("unexpected exposition:\n%s", rr.Body.String())
+	}
+	if !strings.HasPrefix(rr.Header().Get("Content-Type"), "text/plain; version=0.0.4") {
+		t.Errorf("unexpected content type %q", rr.Header().Get("Content-Type"))
+	}
+}
+
+func TestMemoryMetrics_Labels(t *testing.T) {
+	var m MemoryMetrics
+	m.Add("requests_total", 1, Label{Name: "b", Value: "2"}, Label{Name: "a", Value: "1"})
+	m.Add("requests_total", 1, Label{Name: "a", Value: "1"}, Label{Name: "b", Value: "2"})
+	if got := m.Counter("requests_total", Label{Name: "a", Value: "1"}, Label{Name: "b", Value: "2"}); got != 2 {
+		t.Errorf("expected 2, got %v", got)
+	}
+	if got := m.Counter("requests_total"); got != 0 {
+		t.Errorf("expected no unlabelled series, got %v", got)
+	}
+}
+
+func TestTools_MetricsUpload(t *testing.T) {
+	png, err := os.ReadFile("./testdata/img.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	metrics := &MemoryMetrics{}
+	testTools := Tools{allowedTypes: []string{"image/png"}, metrics: metrics}
+
+	request := newMultipartRequest(t, map[string][]byte{"img.png": png}, "img.png")
+	if _, err = testTools.UploadFiles(request, t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	testTools.allowedTypes = []string{"image/jpeg"}
+	request = newMultipartRequest(t, map[string][]byte{"img.png": png}, "img.png")
+	if _, err = testTools.UploadFiles(request, t.TempDir()); err == nil {
+		t.Fatal("expected the upload to be rejected")
+	}
+
+	if got := metrics.Counter(MetricUploads); got != 1 {
+		t.Errorf("expected 1 upload, got %v", got)
+	}
+	if got := metrics.Counter(MetricUploadBytes); got != float64(len(png)) {
+		t.Errorf("expected %d bytes, got %v", len(png), got)
+	}
+	if got := metrics.Observations(MetricUploadSize); len(got) != 1 || got[0] != float64(len(png)) {
+		t.Errorf("unexpected size observations %v", got)
+	}
+	if got := metrics.Counter(MetricUploadRejections, Label{Name: "reason", Value: "type_not_allowed"}); got != 1 {
+		t.Errorf("expected 1 rejection, got %v", got)
+	}
+}
+
+func TestTools_MetricsJSONAndRemote(t *testing.T) {
+	metrics := &MemoryMetrics{}
+	testTools := Tools{metrics: metrics}
+
+	_ = testTools.WriteJSON(httptest.NewRecorder(), http.StatusCreated, struct{}{})
+	_ = testTools.ErrorJSON(httptest.NewRecorder(), os.ErrNotExist, http.StatusNotFound)
+	if got := metrics.Counter(MetricJSONResponses, Label{Name: "status", Value: "201"}); got != 1 {
+		t.Errorf("expected one 201 response, got %v", got)
+	}
+	if got := metrics.Counter(MetricJSONResponses, Label{Name: "status", Value: "404"}); got != 1 {
+		t.Errorf("expected one 404 response, got %v", got)
+	}
+
+	for _, status := range []int{http.StatusOK, http.StatusInternalServerError} {
+		client := NewTestClient(func(req *http.Request) *http.Response {
+			return &http.Response{StatusCode: status, Body: http.NoBody, Header: make(http.Header)}
+		})
+		_, _, _ = testTools.PushJSONToRemote("http://example.com/", struct{}{}, client)
+	}
+	if got := metrics.Counter(MetricRemotePushAttempts); got != 2 {
+		t.Errorf("expected 2 attempts, got %v", got)
+	}
+	if got := metrics.Counter(MetricRemotePushFailures); got != 1 {
+		t.Errorf("expected 1 failure, got %v", got)
+	}
+	if got := metrics.Observations(MetricRemotePushDuration); len(got) != 2 {
+		t.Errorf("expected 2 latency observations, got %v", got)
+	}
+}
@@ -0,0 +1,503 @@
+package toolkit
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Option sets one or more settings of the Tools built by New.
+type Option interface {
+	apply(t *Tools) error
+}
+
+type optionFunc func(t *Tools) error
+
+func (f optionFunc) apply(t *Tools) error {
+	return f(t)
+}
+
+// New returns a Tools configured by opts, or an error if the resulting
+// configuration does not make sense. Options are applied in order, so later
+// options override earlier ones. The configuration cannot be changed once New
+// has returned.
+func New(opts ...Option) (*Tools, error) {
+	t := &Tools{}
+	for _, opt := range opts {
+		if err := opt.apply(t); err != nil {
+			return nil, err
+		}
+	}
+	if err := t.validate(); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+func (t *Tools) validate() error {
+	if t.maxFileSize < 0 {
+		return errors.New("MaxFileSize must not be negative")
+	}
+	if t.maxJSONSize < 0 {
+		return errors.New("MaxJSONSize must not be negative")
+	}
+	if t.uploadRateLimit < 0 {
+		return errors.New("UploadRateLimit must not be negative")
+	}
+	for _, allowed := range t.allowedTypes {
+		if kind, sub, ok := strings.Cut(allowed, "/"); !ok || kind == "" || sub == "" {
+			return fmt.Errorf("Allowed type %q is not a MIME type", allowed)
+		}
+	}
+	if t.quarantineDir != "" && t.scanner == nil {
+		return errors.New("QuarantineDir is only used with a Scanner")
+	}
+	if t.quota != nil {
+		for _, limit := range []QuotaLimit{t.quota.OwnerLimit, t.quota.DirLimit} {
+			if limit.MaxBytes < 0 || limit.MaxFiles < 0 {
+				return errors.New("Quota limits must not be negative")
+			}
+		}
+	}
+	for _, scheme := range []IDScheme{t.uploadIDScheme, t.requestIDScheme} {
+		switch scheme {
+		case "", IDRandomString, IDUUIDv4, IDUUIDv7, IDULID, IDNanoID:
+		default:
+			return fmt.Errorf("Unknown ID scheme %q", scheme)
+		}
+	}
+	if t.remoteRetry != nil && (t.remoteRetry.MaxAttempts < 0 || t.remoteRetry.Backoff < 0 || t.remoteRetry.MaxBackoff < 0) {
+		return errors.New("RemoteRetry settings must not be negative")
+	}
+	return t.passwordHasher.validate()
+}
+
+func (h PasswordHasher) validate() error {
+	switch h.Algorithm {
+	case "", PasswordPBKDF2, PasswordScrypt:
+	default:
+		return fmt.Errorf("Unsupported password hash algorithm %q", h.Algorithm)
+	}
+	if h.Iterations < 0 || h.ScryptR < 0 || h.ScryptP < 0 {
+		return errors.New("Password hash parameters must not be negative")
+	}
+	if h.ScryptLogN < 0 || h.ScryptLogN > maxScryptLogN {
+		return fmt.Errorf("ScryptLogN must not be more than %d", maxScryptLogN)
+	}
+	if h.ScryptR > maxScryptR {
+		return fmt.Errorf("ScryptR must not be more than %d", maxScryptR)
+	}
+	if h.ScryptP > maxScryptP {
+		return fmt.Errorf("ScryptP must not be more than %d", maxScryptP)
+	}
+	if h.Iterations > maxPBKDF2Iterations {
+		return fmt.Errorf("Iterations must not be more than %d", maxPBKDF2Iterations)
+	}
+	if h.SaltLength < 0 || (h.SaltLength > 0 && h.SaltLength < 8) {
+		return errors.New("SaltLength must be at least 8 bytes")
+	}
+	if h.KeyLength < 0 || (h.KeyLength > 0 && h.KeyLength < 16) {
+		return errors.New("KeyLength must be at least 16 bytes")
+	}
+	if h.KeyLength > maxPasswordKeyLen {
+		return fmt.Errorf("KeyLength must not be more than %d bytes", maxPasswordKeyLen)
+	}
+	return nil
+}
+
+// MaxFileSize returns the largest upload accepted, 1GB by default.
+func (t *Tools) MaxFileSize() int64 {
+	if t.maxFileSize == 0 {
+		return 1024 * 1024 * 1024
+	}
+	return t.maxFileSize
+}
+
+// AllowedTypes returns a copy of the MIME types accepted for uploads; any type
+// is accepted when it is empty.
+func (t *Tools) AllowedTypes() []string {
+	return append([]string(nil), t.allowedTypes...)
+}
+
+// MaxJSONSize returns the largest JSON body read, 1MB by default.
+func (t *Tools) MaxJSONSize() int {
+	if t.maxJSONSize == 0 {
+		return 1024 * 1024
+	}
+	return t.maxJSONSize
+}
+
+func (t *Tools) AllowUnknownFields() bool {
+	return t.allowUnknownFields
+}
+
+func (t *Tools) UploadAllOrNothing() bool {
+	return t.uploadAllOrNothing
+}
+
+func (t *Tools) Scanner() Scanner {
+	return t.scanner
+}
+
+// QuarantineDir returns the directory that infected uploads, and those whose
+// scan failed, are moved to. When it is empty, each upload directory gets its
+// own, named after it with a .quarantine suffix.
+func (t *Tools) QuarantineDir() string {
+	return t.quarantineDir
+}
+
+func (t *Tools) Quota() *Quota {
+	return t.quota
+}
+
+func (t *Tools) OnUploadProgress() func(UploadProgress) {
+	return t.onUploadProgress
+}
+
+func (t *Tools) ProgressTracker() *ProgressTracker {
+	return t.progressTracker
+}
+
+// UploadRateLimit returns the bytes per second uploads are read at, 0 meaning
+// no limit.
+func (t *Tools) UploadRateLimit() int64 {
+	return t.uploadRateLimit
+}
+
+func (t *Tools) UploadIDScheme() IDScheme {
+	return t.uploadIDScheme
+}
+
+func (t *Tools) PasswordHasher() PasswordHasher {
+	return t.passwordHasher
+}
+
+func (t *Tools) Logger() *slog.Logger {
+	return t.logger
+}
+
+// Metrics returns the Metrics events are recorded to, NopMetrics by default.
+func (t *Tools) Metrics() Metrics {
+	if t.metrics == nil {
+		return NopMetrics{}
+	}
+	return t.metrics
+}
+
+func (t *Tools) UseProblemJSON() bool {
+	return t.useProblemJSON
+}
+
+// RequestIDHeader returns the header holding request IDs, X-Request-ID by
+// default.
+func (t *Tools) RequestIDHeader() string {
+	if t.requestIDHeader != "" {
+		return t.requestIDHeader
+	}
+	return defaultRequestIDHeader
+}
+
+func (t *Tools) RequestIDScheme() IDScheme {
+	return t.requestIDScheme
+}
+
+// RemoteRetry returns a copy of the retry policy of PushJSONToRemote, or nil
+// when failed requests are not retried.
+func (t *Tools) RemoteRetry() *RetryPolicy {
+	if t.remoteRetry == nil {
+		return nil
+	}
+	policy := *t.remoteRetry
+	return &policy
+}
+
+func WithMaxFileSize(size int64) Option {
+	return optionFunc(func(t *Tools) error {
+		t.maxFileSize = size
+		return nil
+	})
+}
+
+func WithAllowedTypes(types ...string) Option {
+	return optionFunc(func(t *Tools) error {
+		t.allowedTypes = append([]string(nil), types...)
+		return nil
+	})
+}
+
+func WithMaxJSONSize(size int) Option {
+	return optionFunc(func(t *Tools) error {
+		t.maxJSONSize = size
+		return nil
+	})
+}
+
+func WithAllowUnknownFields(allow bool) Option {
+	return optionFunc(func(t *Tools) error {
+		t.allowUnknownFields = allow
+		return nil
+	})
+}
+
+func WithUploadAllOrNothing(enabled bool) Option {
+	return optionFunc(func(t *Tools) error {
+		t.uploadAllOrNothing = enabled
+		return nil
+	})
+}
+
+func WithScanner(scanner Scanner, quarantineDir string) Option {
+	return optionFunc(func(t *Tools) error {
+		t.scanner = scanner
+		t.quarantineDir = quarantineDir
+		return nil
+	})
+}
+
+func WithQuota(quota *Quota) Option {
+	return optionFunc(func(t *Tools) error {
+		t.quota = quota
+		return nil
+	})
+}
+
+func WithUploadProgress(callback func(UploadProgress)) Option {
+	return optionFunc(func(t *Tools) error {
+		t.onUploadProgress = callback
+		return nil
+	})
+}
+
+func WithProgressTracker(retention time.Duration) Option {
+	return optionFunc(func(t *Tools) error {
+		t.progressTracker = t.NewProgressTracker()
+		t.progressTracker.Retention = retention
+		return nil
+	})
+}
+
+func WithUploadRateLimit(bytesPerSecond int64) Option {
+	return optionFunc(func(t *Tools) error {
+		t.uploadRateLimit = bytesPerSecond
+		return nil
+	})
+}
+
+func WithUploadIDScheme(scheme IDScheme) Option {
+	return optionFunc(func(t *Tools) error {
+		t.uploadIDScheme = scheme
+		return nil
+	})
+}
+
+func WithPasswordHasher(hasher PasswordHasher) Option {
+	return optionFunc(func(t *Tools) error {
+		t.passwordHasher = hasher
+		return nil
+	})
+}
+
+func WithLogger(logger *slog.Logger) Option {
+	return optionFunc(func(t *Tools) error {
+		t.logger = logger
+		return nil
+	})
+}
+
+func WithMetrics(metrics Metrics) Option {
+	return optionFunc(func(t *Tools) error {
+		t.metrics = metrics
+		return nil
+	})
+}
+
+func WithProblemJSON(enabled bool) Option {
+	return optionFunc(func(t *Tools) error {
+		t.useProblemJSON = enabled
+		return nil
+	})
+}
+
+// WithRequestID sets the header the RequestID middleware reads and echoes, and
+// the scheme of the IDs it generates.
+func WithRequestID(header string, scheme IDScheme) Option {
+	return optionFunc(func(t *Tools) error {
+		t.requestIDHeader = header
+		t.requestIDScheme = scheme
+		return nil
+	})
+}
+
+// WithRemoteRetry makes PushJSONToRemote retry failed requests as set out by
+// policy.
+func WithRemoteRetry(policy RetryPolicy) Option {
+	return optionFunc(func(t *Tools) error {
+		t.remoteRetry = &policy
+		return nil
+	})
+}
+
+// WithEnv reads the configuration from environment variables named after the
+// settings, prefix included: MAX_FILE_SIZE, ALLOWED_TYPES (comma separated),
+// MAX_JSON_SIZE, ALLOW_UNKNOWN_FIELDS, UPLOAD_ALL_OR_NOTHING, CLAMD_ADDRESS
+// (tcp://host:port or unix:///path), QUARANTINE_DIR, UPLOAD_RATE_LIMIT,
+// UPLOAD_ID_SCHEME, PASSWORD_ALGORITHM, PASSWORD_ITERATIONS, SCRYPT_LOG_N,
+// SCRYPT_R, SCRYPT_P, USE_PROBLEM_JSON, REQUEST_ID_HEADER, REQUEST_ID_SCHEME,
+// REMOTE_RETRY_MAX_ATTEMPTS, REMOTE_RETRY_BACKOFF, REMOTE_RETRY_MAX_BACKOFF and
+// REMOTE_RETRY_IDEMPOTENCY_KEYS. Sizes accept a K, M or G suffix and durations
+// use the time.ParseDuration syntax. Unset variables leave the setting
+// unchanged. Settings holding functions or other Go values, such as Logger,
+// Metrics, Quota and the upload progress hooks, can only be set in code.
+// The prefix defaults to "TOOLKIT_".
+func WithEnv(prefix string) Option {
+	if prefix == "" {
+		prefix = "TOOLKIT_"
+	}
+	return optionFunc(func(t *Tools) error {
+		env := func(name string) (string, bool) {
+			return os.LookupEnv(prefix + name)
+		}
+		var err error
+		if v, ok := env("MAX_FILE_SIZE"); ok {
+			if t.maxFileSize, err = parseByteSize(v); err != nil {
+				return fmt.Errorf("%sMAX_FILE_SIZE: %w", prefix, err)
+			}
+		}
+		if v, ok := env("ALLOWED_TYPES"); ok {
+			t.allowedTypes = nil
+			for _, allowed := range strings.Split(v, ",") {
+				if allowed = strings.TrimSpace(allowed); allowed != "" {
+					t.allowedTypes = append(t.allowedTypes, allowed)
+				}
+			}
+		}
+		if v, ok := env("MAX_JSON_SIZE"); ok {
+			size, err := parseByteSize(v)
+			if err == nil && size > math.MaxInt {
+				err = fmt.Errorf("Size %q is too large", v)
+			}
+			if err != nil {
+				return fmt.Errorf("%sMAX_JSON_SIZE: %w", prefix, err)
+			}
+			t.maxJSONSize = int(size)
+		}
+		if v, ok := env("ALLOW_UNKNOWN_FIELDS"); ok {
+			if t.allowUnknownFields, err = strconv.ParseBool(v); err != nil {
+				return fmt.Errorf("%sALLOW_UNKNOWN_FIELDS: %w", prefix, err)
+			}
+		}
+		if v, ok := env("UPLOAD_ALL_OR_NOTHING"); ok {
+			if t.uploadAllOrNothing, err = strconv.ParseBool(v); err != nil {
+				return fmt.Errorf("%sUPLOAD_ALL_OR_NOTHING: %w", prefix, err)
+			}
+		}
+		if v, ok := env("CLAMD_ADDRESS"); ok {
+			network, address, found := strings.Cut(v, "://")
+			if !found || (network != "tcp" && network != "unix") {
+				return fmt.Errorf("%sCLAMD_ADDRESS must start with tcp:// or unix://", prefix)
+			}
+			t.scanner = &ClamdScanner{Network: network, Address: address}
+		}
+		if v, ok := env("QUARANTINE_DIR"); ok {
+			t.quarantineDir = v
+		}
+		if v, ok := env("UPLOAD_RATE_LIMIT"); ok {
+			if t.uploadRateLimit, err = parseByteSize(v); err != nil {
+				return fmt.Errorf("%sUPLOAD_RATE_LIMIT: %w", prefix, err)
+			}
+		}
+		if v, ok := env("UPLOAD_ID_SCHEME"); ok {
+			t.uploadIDScheme = IDScheme(strings.ToLower(v))
+		}
+		if v, ok := env("PASSWORD_ALGORITHM"); ok {
+			t.passwordHasher.Algorithm = v
+		}
+		// Slices rather than maps, so that errors are reported in a fixed order.
+		for _, setting := range []struct {
+			name  string
+			field *int
+		}{
+			{"PASSWORD_ITERATIONS", &t.passwordHasher.Iterations},
+			{"SCRYPT_LOG_N", &t.passwordHasher.ScryptLogN},
+			{"SCRYPT_R", &t.passwordHasher.ScryptR},
+			{"SCRYPT_P", &t.passwordHasher.ScryptP},
+		} {
+			if v, ok := env(setting.name); ok {
+				if *setting.field, err = strconv.Atoi(v); err != nil {
+					return fmt.Errorf("%s%s: %w", prefix, setting.name, err)
+				}
+			}
+		}
+		if v, ok := env("USE_PROBLEM_JSON"); ok {
+			if t.useProblemJSON, err = strconv.ParseBool(v); err != nil {
+				return fmt.Errorf("%sUSE_PROBLEM_JSON: %w", prefix, err)
+			}
+		}
+		if v, ok := env("REQUEST_ID_HEADER"); ok {
+			t.requestIDHeader = v
+		}
+		if v, ok := env("REQUEST_ID_SCHEME"); ok {
+			t.requestIDScheme = IDScheme(strings.ToLower(v))
+		}
+		retry := func() *RetryPolicy {
+			if t.remoteRetry == nil {
+				t.remoteRetry = &RetryPolicy{}
+			}
+			return t.remoteRetry
+		}
+		if v, ok := env("REMOTE_RETRY_MAX_ATTEMPTS"); ok {
+			if retry().MaxAttempts, err = strconv.Atoi(v); err != nil {
+				return fmt.Errorf("%sREMOTE_RETRY_MAX_ATTEMPTS: %w", prefix, err)
+			}
+		}
+		for _, setting := range []struct {
+			name  string
+			field func() *time.Duration
+		}{
+			{"REMOTE_RETRY_BACKOFF", func() *time.Duration { return &retry().Backoff }},
+			{"REMOTE_RETRY_MAX_BACKOFF", func() *time.Duration { return &retry().MaxBackoff }},
+		} {
+			if v, ok := env(setting.name); ok {
+				if *setting.field(), err = time.ParseDuration(v); err != nil {
+					return fmt.Errorf("%s%s: %w", prefix, setting.name, err)
+				}
+			}
+		}
+		if v, ok := env("REMOTE_RETRY_IDEMPOTENCY_KEYS"); ok {
+			if retry().IdempotencyKeys, err = strconv.ParseBool(v); err != nil {
+				return fmt.Errorf("%sREMOTE_RETRY_IDEMPOTENCY_KEYS: %w", prefix, err)
+			}
+		}
+		return nil
+	})
+}
+
+func parseByteSize(value string) (int64, error) {
+	s := strings.ToUpper(strings.TrimSpace(value))
+	s = strings.TrimSuffix(strings.TrimSuffix(s, "B"), "I")
+	multiplier := int64(1)
+	switch {
+	case strings.HasSuffix(s, "K"):
+		multiplier = 1024
+	case strings.HasSuffix(s, "M"):
+		multiplier = 1024 * 1024
+	case strings.HasSuffix(s, "G"):
+		multiplier = 1024 * 1024 * 1024
+	}
+	if multiplier > 1 {
+		s = s[:len(s)-1]
+	}
+	n, err := strconv.ParseInt(strings.TrimSpace(s), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("Invalid size %q", value)
+	}
+	if n > math.MaxInt64/multiplier || n < math.MinInt64/multiplier {
+		return 0, fmt.Errorf("Size %q is too large", value)
+	}
+	return n * multiplier, nil
+}
@@ -0,0 +1,157 @@
+package toolkit
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+var optionTests = []struct {
+	name          string
+	options       []Option
+	errorExpected bool
+}{
+	{name: "no options"},
+	{
+		name: "valid options",
+		options: []Option{
+			WithMaxFileSize(10 << 20),
+			WithAllowedTypes("image/png", "image/jpeg"),
+			WithMaxJSONSize(1 << 20),
+			WithUploadIDScheme(IDULID),
+			WithPasswordHasher(PasswordHasher{Algorithm: PasswordScrypt}),
+			WithRemoteRetry(RetryPolicy{MaxAttempts: 3, Backoff: time.Second, IdempotencyKeys: true}),
+		},
+	},
+	{name: "negative file size", options: []Option{WithMaxFileSize(-1)}, errorExpected: true},
+	{name: "negative JSON size", options: []Option{WithMaxJSONSize(-1)}, errorExpected: true},
+	{name: "bad MIME type", options: []Option{WithAllowedTypes("png")}, errorExpected: true},
+	{name: "quarantine without scanner", options: []Option{WithScanner(nil, "/tmp/q")}, errorExpected: true},
+	{name: "unknown ID scheme", options: []Option{WithUploadIDScheme("snowflake")}, errorExpected: true},
+	{name: "short salt", options: []Option{WithPasswordHasher(PasswordHasher{SaltLength: 4})}, errorExpected: true},
+	{name: "scrypt r too large", options: []Option{WithPasswordHasher(PasswordHasher{Algorithm: PasswordScrypt, ScryptR: 1000})}, errorExpected: true},
+	{name: "scrypt p too large", options: []Option{WithPasswordHasher(PasswordHasher{Algorithm: PasswordScrypt, ScryptP: 1000})}, errorExpected: true},
+	{name: "unknown algorithm", options: []Option{WithPasswordHasher(PasswordHasher{Algorithm: "md5"})}, errorExpected: true},
+	{name: "negative retry backoff", options: []Option{WithRemoteRetry(RetryPolicy{Backoff: -time.Second})}, errorExpected: true},
+	{name: "negative quota", options: []Option{WithQuota(&Quota{DirLimit: QuotaLimit{MaxFiles: -1}})}, errorExpected: true},
+}
+
+func TestNew(t *testing.T) {
+	for _, e := range optionTests {
+		tools, err := New(e.options...)
+		if err != nil && !e.errorExpected {
+			t.Errorf("%s - Error not expected, but got one : %s", e.name, err)
+		}
+		if err == nil && e.errorExpected {
+			t.Errorf("%s - Error expected, but got none.", e.name)
+		}
+		if err == nil && tools == nil {
+			t.Errorf("%s - No Tools returned", e.name)
+		}
+	}
+}
+
+func TestNew_CopiesAllowedTypes(t *testing.T) {
+	types := []string{"image/png"}
+	tools, err := New(WithAllowedTypes(types...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	types[0] = "text/plain"
+	tools.AllowedTypes()[0] = "text/plain"
+	if tools.allowedTypes[0] != "image/png" {
+		t.Error("Configuration changed after construction")
+	}
+}
+
+func TestTools_Accessors(t *testing.T) {
+	var zero Tools
+	if zero.MaxFileSize() != 1<<30 || zero.MaxJSONSize() != 1<<20 || zero.RequestIDHeader() != "X-Request-ID" || zero.RemoteRetry() != nil {
+		t.Error("Zero value does not report the defaults")
+	}
+	if _, ok := zero.Metrics().(NopMetrics); !ok {
+		t.Errorf("Expected NopMetrics by default, got %T", zero.Metrics())
+	}
+
+	tools, err := New(WithMaxFileSize(5<<20), WithRemoteRetry(RetryPolicy{MaxAttempts: 2}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tools.RemoteRetry().MaxAttempts = 10
+	if tools.MaxFileSize() != 5<<20 || tools.RemoteRetry().MaxAttempts != 2 {
+		t.Error("Configuration changed after construction")
+	}
+}
+
+func TestWithEnv(t *testing.T) {
+	t.Setenv("APP_MAX_FILE_SIZE", "10MB")
+	t.Setenv("APP_ALLOWED_TYPES", "image/png, image/gif")
+	t.Setenv("APP_MAX_JSON_SIZE", "64K")
+	t.Setenv("APP_ALLOW_UNKNOWN_FIELDS", "true")
+	t.Setenv("APP_CLAMD_ADDRESS", "tcp://127.0.0.1:3310")
+	t.Setenv("APP_UPLOAD_ID_SCHEME", "UUIDv7")
+	t.Setenv("APP_PASSWORD_ITERATIONS", "700000")
+	t.Setenv("APP_USE_PROBLEM_JSON", "true")
+	t.Setenv("APP_REQUEST_ID_HEADER", "X-Correlation-ID")
+	t.Setenv("APP_REQUEST_ID_SCHEME", "ULID")
+	t.Setenv("APP_REMOTE_RETRY_MAX_ATTEMPTS", "4")
+	t.Setenv("APP_REMOTE_RETRY_BACKOFF", "250ms")
+	t.Setenv("APP_REMOTE_RETRY_IDEMPOTENCY_KEYS", "true")
+
+	tools, err := New(WithMaxJSONSize(1), WithEnv("APP_"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tools.maxFileSize != 10<<20 || tools.maxJSONSize != 64<<10 || !tools.allowUnknownFields {
+		t.Errorf("Sizes or flags not loaded: %+v", tools)
+	}
+	if strings.Join(tools.allowedTypes, ",") != "image/png,image/gif" {
+		t.Errorf("Allowed types not loaded: %v", tools.allowedTypes)
+	}
+	if scanner, ok := tools.scanner.(*ClamdScanner); !ok || scanner.Network != "tcp" || scanner.Address != "127.0.0.1:3310" {
+		t.Errorf("Scanner not loaded: %#v", tools.scanner)
+	}
+	if tools.uploadIDScheme != IDUUIDv7 || tools.passwordHasher.Iterations != 700000 {
+		t.Errorf("ID scheme or password settings not loaded: %+v", tools)
+	}
+	if !tools.useProblemJSON || tools.requestIDHeader != "X-Correlation-ID" || tools.requestIDScheme != IDULID {
+		t.Errorf("Error or request ID settings not loaded: %+v", tools)
+	}
+	if retry := tools.remoteRetry; retry == nil || retry.MaxAttempts != 4 || retry.Backoff != 250*time.Millisecond || !retry.IdempotencyKeys {
+		t.Errorf("Retry policy not loaded: %+v", tools.remoteRetry)
+	}
+
+	for _, size := range []string{"lots", "9000000000G", "-9000000000G"} {
+		t.Setenv("APP_MAX_FILE_SIZE", size)
+		if _, err = New(WithEnv("APP_")); err == nil {
+			t.Errorf("Error expected for size %s, but got none.", size)
+		}
+	}
+
+	t.Setenv("APP_MAX_FILE_SIZE", "1M")
+	t.Setenv("APP_PASSWORD_ITERATIONS", "many")
+	t.Setenv("APP_SCRYPT_P", "few")
+	for i := 0; i < 10; i++ {
+		if _, err = New(WithEnv("APP_")); err == nil || !strings.HasPrefix(err.Error(), "APP_PASSWORD_ITERATIONS") {
+			t.Fatalf("Expected the first invalid variable to be reported, got %v", err)
+		}
+	}
+}
+
+func TestTools_ReadJSONDefaultSize(t *testing.T) {
+	var testTools Tools
+	var decoded struct {
+		Name string `json:"name"`
+	}
+	body := `{"name":"` + strings.Repeat("a", 4096) + `"}`
+	req, _ := http.NewRequest("POST", "/", bytes.NewReader([]byte(body)))
+	if err := testTools.ReadJSON(httptest.NewRecorder(), *req, &decoded); err != nil {
+		t.Errorf("Body under the default limit was rejected: %s", err)
+	}
+	if testTools.maxJSONSize != 0 || testTools.maxFileSize != 0 {
+		t.Error("Configuration was modified")
+	}
+}
@@ -0,0 +1,267 @@
+package toolkit
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+const (
+	pageParam   = "page"
+	limitParam  = "limit"
+	cursorParam = "cursor"
+	// maxPage keeps the offset of a page from overflowing.
+	maxPage = math.MaxInt32
+)
+
+var ErrCursorInvalid = errors.New("Cursor is invalid")
+
+// Paginator parses the page, limit and cursor query parameters of list
+// endpoints and builds the paginated response. Limits default to DefaultLimit,
+// 20 when unset, and are capped at MaxLimit, 100 when unset.
+//
+// Cursors are opaque to clients: they hold a JSON encoded value, such as the
+// sort key of the last item of a page, signed with HMAC-SHA256 so that clients
+// cannot craft their own. Keys work as for URLSigner, so they can be rotated.
+//
+// The errors returned by the Parse methods are meant to be sent back to the
+// client with a 400 status.
+type Paginator struct {
+	DefaultLimit int
+	MaxLimit     int
+	Keys         map[string][]byte
+	CurrentKey   string
+}
+
+// OffsetPage is a page of an offset-based listing. Pages start at 1.
+type OffsetPage struct {
+	Page  int
+	Limit int
+}
+
+func (p OffsetPage) Offset() int {
+	return (p.Page - 1) * p.Limit
+}
+
+// CursorPage is a page of a cursor-based listing. HasCursor is false for the
+// first page.
+type CursorPage struct {
+	Limit     int
+	HasCursor bool
+}
+
+// PageInfo describes the page returned in a Paginated response. Page, Total
+// and TotalPages are only set for offset-based listings, NextCursor and
+// PrevCursor only for cursor-based ones.
+type PageInfo struct {
+	Page       int    `json:"page,omitempty"`
+	Limit      int    `json:"limit"`
+	Total      *int   `json:"total,omitempty"`
+	TotalPages int    `json:"total_pages,omitempty"`
+	NextCursor string `json:"next_cursor,omitempty"`
+	PrevCursor string `json:"prev_cursor,omitempty"`
+	HasMore    bool   `json:"has_more"`
+}
+
+// Paginated is the envelope of a page of results, to be written with
+// WriteJSON along with the headers returned by OffsetResult or CursorResult.
+type Paginated struct {
+	Data       interface{} `json:"data"`
+	Pagination PageInfo    `json:"pagination"`
+}
+
+func (p *Paginator) defaultLimit() int {
+	if p.DefaultLimit > 0 {
+		return min(p.DefaultLimit, p.maxLimit())
+	}
+	return min(20, p.maxLimit())
+}
+
+func (p *Paginator) maxLimit() int {
+	if p.MaxLimit > 0 {
+		return p.MaxLimit
+	}
+	return 100
+}
+
+func (p *Paginator) parseLimit(r *http.Request) (int, error) {
+	v := r.URL.Query().Get(limitParam)
+	if v == "" {
+		return p.defaultLimit(), nil
+	}
+	limit, err := strconv.Atoi(v)
+	if err != nil || limit < 1 {
+		return 0, fmt.Errorf("Query parameter %s must be a positive integer", limitParam)
+	}
+	return min(limit, p.maxLimit()), nil
+}
+
+// ParseOffset reads the page and limit query parameters of r.
+func (p *Paginator) ParseOffset(r *http.Request) (OffsetPage, error) {
+	limit, err := p.parseLimit(r)
+	if err != nil {
+		return OffsetPage{}, err
+	}
+	page := 1
+	if v := r.URL.Query().Get(pageParam); v != "" {
+		page, err = strconv.Atoi(v)
+		if err != nil || page < 1 || page > maxPage/limit {
+			return OffsetPage{}, fmt.Errorf("Query parameter %s must be a positive integer", pageParam)
+		}
+	}
+	return OffsetPage{Page: page, Limit: limit}, nil
+}
+
+// ParseCursor reads the limit and cursor query parameters of r, decoding the
+// cursor, if any, into dst.
+func (p *Paginator) ParseCursor(r *http.Request, dst interface{}) (CursorPage, error) {
+	limit, err := p.parseLimit(r)
+	if err != nil {
+		return CursorPage{}, err
+	}
+	cursor := r.URL.Query().Get(cursorParam)
+	if cursor == "" {
+		return CursorPage{Limit: limit}, nil
+	}
+	if err = p.DecodeCursor(cursor, dst); err != nil {
+		return CursorPage{}, err
+	}
+	return CursorPage{Limit: limit, HasCursor: true}, nil
+}
+
+// EncodeCursor returns the signed cursor holding value.
+func (p *Paginator) EncodeCursor(value interface{}) (string, error) {
+	key, ok := p.Keys[p.CurrentKey]
+	if !ok {
+		return "", errors.New("Current cursor key is not in the key set")
+	}
+	data, err := json.Marshal(value)
+	if err != nil {
+		return "", err
+	}
+	payload := base64.RawURLEncoding.EncodeToString([]byte(p.CurrentKey)) + "." + base64.RawURLEncoding.EncodeToString(data)
+	return payload + "." + base64.RawURLEncoding.EncodeToString(cursorMAC(key, payload)), nil
+}
+
+// DecodeCursor verifies cursor and unmarshals its value into dst. It returns
+// ErrCursorInvalid for tampered cursors and cursors signed with an unknown key.
+func (p *Paginator) DecodeCursor(cursor string, dst interface{}) error {
+	parts := strings.Split(cursor, ".")
+	if len(parts) != 3 {
+		return ErrCursorInvalid
+	}
+	kid, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return ErrCursorInvalid
+	}
+	key, ok := p.Keys[string(kid)]
+	if !ok {
+		return ErrCursorInvalid
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil || !hmac.Equal(signature, cursorMAC(key, parts[0]+"."+parts[1])) {
+		return ErrCursorInvalid
+	}
+	data, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil || json.Unmarshal(data, dst) != nil {
+		return ErrCursorInvalid
+	}
+	return nil
+}
+
+func cursorMAC(key []byte, payload string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte("cursor." + payload))
+	return mac.Sum(nil)
+}
+
+// OffsetResult wraps data, the items of page out of total, in a Paginated
+// envelope, and returns a Link header pointing to the first, previous, next
+// and last pages. A page not built by ParseOffset gets the default limit when
+// its Limit is unset and starts at page 1.
+func (p *Paginator) OffsetResult(r *http.Request, page OffsetPage, total int, data interface{}) (*Paginated, http.Header) {
+	if page.Limit < 1 {
+		page.Limit = p.defaultLimit()
+	}
+	page.Page = max(page.Page, 1)
+	totalPages := (total + page.Limit - 1) / page.Limit
+	links := []pageLink{{rel: "first", params: offsetParams(1, page.Limit)}}
+	if page.Page > 1 {
+		links = append(links, pageLink{rel: "prev", params: offsetParams(min(page.Page-1, max(totalPages, 1)), page.Limit)})
+	}
+	if page.Page < totalPages {
+		links = append(links, pageLink{rel: "next", params: offsetParams(page.Page+1, page.Limit)})
+	}
+	links = append(links, pageLink{rel: "last", params: offsetParams(max(totalPages, 1), page.Limit)})
+
+	return &Paginated{
+		Data: data,
+		Pagination: PageInfo{
+			Page:       page.Page,
+			Limit:      page.Limit,
+			Total:      &total,
+			TotalPages: totalPages,
+			HasMore:    page.Page < totalPages,
+		},
+	}, linkHeader(r, links)
+}
+
+// CursorResult wraps data in a Paginated envelope with cursors for the next
+// and previous pages, built from next and prev; either is nil when there is no
+// such page. The returned Link header points to the first, previous and next
+// pages.
+func (p *Paginator) CursorResult(r *http.Request, page CursorPage, next, prev interface{}, data interface{}) (*Paginated, http.Header, error) {
+	info := PageInfo{Limit: page.Limit}
+	links := []pageLink{{rel: "first", params: url.Values{limitParam: {strconv.Itoa(page.Limit)}}}}
+	var err error
+	if prev != nil {
+		if info.PrevCursor, err = p.EncodeCursor(prev); err != nil {
+			return nil, nil, err
+		}
+		links = append(links, pageLink{rel: "prev", params: url.Values{cursorParam: {info.PrevCursor}, limitParam: {strconv.Itoa(page.Limit)}}})
+	}
+	if next != nil {
+		if info.NextCursor, err = p.EncodeCursor(next); err != nil {
+			return nil, nil, err
+		}
+		info.HasMore = true
+		links = append(links, pageLink{rel: "next", params: url.Values{cursorParam: {info.NextCursor}, limitParam: {strconv.Itoa(page.Limit)}}})
+	}
+	return &Paginated{Data: data, Pagination: info}, linkHeader(r, links), nil
+}
+
+type pageLink struct {
+	rel    string
+	params url.Values
+}
+
+func offsetParams(page, limit int) url.Values {
+	return url.Values{pageParam: {strconv.Itoa(page)}, limitParam: {strconv.Itoa(limit)}}
+}
+
+// linkHeader returns an RFC 8288 Link header with a link per page. Links are
+// relative to the request, keeping its other query parameters, such as
+// filters and sort order.
+func linkHeader(r *http.Request, links []pageLink) http.Header {
+	values := make([]string, 0, len(links))
+	for _, link := range links {
+		query := r.URL.Query()
+		for _, param := range []string{pageParam, limitParam, cursorParam} {
+			query.Del(param)
+		}
+		for param, v := range link.params {
+			query[param] = v
+		}
+		u := url.URL{Path: r.URL.Path, RawQuery: query.Encode()}
+		values = append(values, fmt.Sprintf("<%s>; rel=%q", u.String(), link.rel))
+	}
+	return http.Header{"Link": {strings.Join(values, ", ")}}
+}
@@ -0,0 +1,150 @@
+package toolkit
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+var parseOffsetTests = []struct {
+	name          string
+	query         string
+	page          int
+	limit         int
+	offset        int
+	errorExpected bool
+}{
+	{name: "defaults", query: "", page: 1, limit: 20, offset: 0},
+	{name: "page and limit", query: "page=3&limit=10", page: 3, limit: 10, offset: 20},
+	{name: "limit capped", query: "limit=1000", page: 1, limit: 100, offset: 0},
+	{name: "zero page", query: "page=0", errorExpected: true},
+	{name: "negative limit", query: "limit=-5", errorExpected: true},
+	{name: "not a number", query: "page=two", errorExpected: true},
+	{name: "huge page", query: "page=999999999999", errorExpected: true},
+}
+
+func TestPaginator_ParseOffset(t *testing.T) {
+	var p Paginator
+	for _, e := range parseOffsetTests {
+		page, err := p.ParseOffset(httptest.NewRequest("GET", "/items?"+e.query, nil))
+		if e.errorExpected {
+			if err == nil {
+				t.Errorf("%s: expected an error", e.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error %v", e.name, err)
+			continue
+		}
+		if page.Page != e.page || page.Limit != e.limit || page.Offset() != e.offset {
+			t.Errorf("%s: unexpected page %+v (offset %d)", e.name, page, page.Offset())
+		}
+	}
+}
+
+type itemCursor struct {
+	ID int `json:"id"`
+}
+
+func TestPaginator_Cursor(t *testing.T) {
+	keys := map[string][]byte{"old": []byte("old secret"), "new": []byte("new secret")}
+	p := &Paginator{Keys: keys, CurrentKey: "old", DefaultLimit: 5}
+	cursor, err := p.EncodeCursor(itemCursor{ID: 42})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rotated := &Paginator{Keys: keys, CurrentKey: "new"}
+	var got itemCursor
+	page, err := rotated.ParseCursor(httptest.NewRequest("GET", "/items?cursor="+cursor, nil), &got)
+	if err != nil || !page.HasCursor || got.ID != 42 || page.Limit != 20 {
+		t.Errorf("unexpected page %+v, cursor %+v, %v", page, got, err)
+	}
+
+	page, err = p.ParseCursor(httptest.NewRequest("GET", "/items", nil), &got)
+	if err != nil || page.HasCursor || page.Limit != 5 {
+		t.Errorf("unexpected first page %+v, %v", page, err)
+	}
+
+	tampered := strings.Replace(cursor, cursor[len(cursor)-2:], "AA", 1)
+	for _, bad := range []string{tampered, "abc", cursor + ".x"} {
+		if _, err = p.ParseCursor(httptest.NewRequest("GET", "/items?cursor="+bad, nil), &got); err != ErrCursorInvalid {
+			t.Errorf("cursor %q: expected ErrCursorInvalid, got %v", bad, err)
+		}
+	}
+	if err = (&Paginator{Keys: map[string][]byte{"new": keys["new"]}}).DecodeCursor(cursor, &got); err != ErrCursorInvalid {
+		t.Errorf("expected a cursor signed with a removed key to be rejected, got %v", err)
+	}
+}
+
+func TestPaginator_OffsetResult(t *testing.T) {
+	var p Paginator
+	var testTools Tools
+	r := httptest.NewRequest("GET", "/items?sort=name&page=2&limit=10", nil)
+	page, _ := p.ParseOffset(r)
+	envelope, headers := p.OffsetResult(r, page, 35, []string{"a", "b"})
+
+	expected := `</items?limit=10&page=1&sort=name>; rel="first", ` +
+		`</items?limit=10&page=1&sort=name>; rel="prev", ` +
+		`</items?limit=10&page=3&sort=name>; rel="next", ` +
+		`</items?limit=10&page=4&sort=name>; rel="last"`
+	if link := headers.Get("Link"); link != expected {
+		t.Errorf("unexpected Link header %s", link)
+	}
+
+	rr := httptest.NewRecorder()
+	if err := testTools.WriteJSON(rr, 200, envelope, headers); err != nil {
+		t.Fatal(err)
+	}
+	var body struct {
+		Data       []string `json:"data"`
+		Pagination PageInfo `json:"pagination"`
+	}
+	if err := json.NewDecoder(rr.Body).Decode(&body); err != nil {
+		t.Fatal(err)
+	}
+	info := body.Pagination
+	if len(body.Data) != 2 || info.Page != 2 || info.Total == nil || *info.Total != 35 || info.TotalPages != 4 || !info.HasMore {
+		t.Errorf("unexpected envelope %+v", body)
+	}
+	if rr.Header().Get("Link") != expected {
+		t.Error("Link header not written")
+	}
+
+	_, headers = p.OffsetResult(r, OffsetPage{Page: 1, Limit: 10}, 0, nil)
+	if link := headers.Get("Link"); strings.Contains(link, `rel="next"`) || strings.Contains(link, `rel="prev"`) {
+		t.Errorf("unexpected links for an empty listing %s", link)
+	}
+
+	envelope, _ = p.OffsetResult(r, OffsetPage{}, 35, nil)
+	if info = envelope.Pagination; info.Page != 1 || info.Limit != 20 || info.TotalPages != 2 {
+		t.Errorf("unexpected page info for a zero page %+v", info)
+	}
+}
+
+func TestPaginator_CursorResult(t *testing.T) {
+	p := &Paginator{Keys: map[string][]byte{"k1": []byte("secret")}, CurrentKey: "k1"}
+	r := httptest.NewRequest("GET", "/items?status=open", nil)
+	page, _ := p.ParseCursor(r, &itemCursor{})
+	envelope, headers, err := p.CursorResult(r, page, itemCursor{ID: 20}, nil, []int{1, 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	info := envelope.Pagination
+	if info.NextCursor == "" || info.PrevCursor != "" || !info.HasMore || info.Total != nil {
+		t.Errorf("unexpected page info %+v", info)
+	}
+	link := headers.Get("Link")
+	if !strings.Contains(link, `</items?limit=20&status=open>; rel="first"`) ||
+		!strings.Contains(link, "cursor="+info.NextCursor+"&limit=20&status=open>; rel=\"next\"") ||
+		strings.Contains(link, `rel="prev"`) {
+		t.Errorf("unexpected Link header %s", link)
+	}
+
+	var next itemCursor
+	if err = p.DecodeCursor(info.NextCursor, &next); err != nil || next.ID != 20 {
+		t.Errorf("unexpected next cursor %+v, %v", next, err)
+	}
+}
@@ -0,0 +1,382 @@
+package toolkit
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+const (
+	PasswordPBKDF2 = "pbkdf2-sha256"
+	PasswordScrypt = "scrypt"
+)
+
+// PasswordHasher produces self-describing hashes in the PHC string format, for
+// instance "$pbkdf2-sha256$i=600000$<salt>$<hash>" or
+// "$scrypt$ln=15,r=8,p=1$<salt>$<hash>", so that the parameters used for a
+// hash are always known when verifying it. Zero fields use the defaults.
+type PasswordHasher struct {
+	Algorithm  string
+	Iterations int
+	ScryptLogN int
+	ScryptR    int
+	ScryptP    int
+	SaltLength int
+	KeyLength  int
+}
+
+type passwordHash struct {
+	algorithm  string
+	iterations int
+	logN       int
+	r          int
+	p          int
+	salt       []byte
+	key        []byte
+}
+
+// Upper bounds on the parameters read from an encoded hash, so that a forged
+// hash cannot make verification arbitrarily expensive. The scrypt bounds also
+// cap its memory use, which is 128 * r * 2^ln bytes.
+const (
+	maxPBKDF2Iterations = 10_000_000
+	maxScryptLogN       = 22
+	maxScryptR          = 32
+	maxScryptP          = 16
+	maxPasswordKeyLen   = 64
+)
+
+func (h PasswordHasher) Hash(password string) (string, error) {
+	params := h.params()
+	params.salt = make([]byte, h.saltLength())
+	if _, err := rand.Read(params.salt); err != nil {
+		return "", err
+	}
+	key, err := params.derive(password, h.keyLength())
+	if err != nil {
+		return "", err
+	}
+	params.key = key
+	return params.String(), nil
+}
+
+// Verify reports whether password matches the encoded hash, whatever the
+// parameters it was created with. The comparison runs in constant time.
+func (h PasswordHasher) Verify(password, encoded string) (bool, error) {
+	stored, err := parsePasswordHash(encoded)
+	if err != nil {
+		return false, err
+	}
+	key, err := stored.derive(password, len(stored.key))
+	if err != nil {
+		return false, err
+	}
+	return subtle.ConstantTimeCompare(key, stored.key) == 1, nil
+}
+
+// NeedsRehash reports whether the encoded hash was created with another
+// algorithm or with parameters different from the current ones, in which case
+// the password should be hashed again after a successful verification.
+func (h PasswordHasher) NeedsRehash(encoded string) bool {
+	stored, err := parsePasswordHash(encoded)
+	if err != nil {
+		return true
+	}
+	current := h.params()
+	return stored.algorithm != current.algorithm ||
+		stored.iterations != current.iterations ||
+		stored.logN != current.logN ||
+		stored.r != current.r ||
+		stored.p != current.p ||
+		len(stored.salt) != h.saltLength() ||
+		len(stored.key) != h.keyLength()
+}
+
+func (h PasswordHasher) params() passwordHash {
+	if h.Algorithm == PasswordScrypt {
+		p := passwordHash{algorithm: PasswordScrypt, logN: h.ScryptLogN, r: h.ScryptR, p: h.ScryptP}
+		if p.logN == 0 {
+			p.logN = 15
+		}
+		if p.r == 0 {
+			p.r = 8
+		}
+		if p.p == 0 {
+			p.p = 1
+		}
+		return p
+	}
+	p := passwordHash{algorithm: PasswordPBKDF2, iterations: h.Iterations}
+	if p.iterations == 0 {
+		p.iterations = 600_000
+	}
+	return p
+}
+
+func (h PasswordHasher) saltLength() int {
+	if h.SaltLength == 0 {
+		return 16
+	}
+	return h.SaltLength
+}
+
+func (h PasswordHasher) keyLength() int {
+	if h.KeyLength == 0 {
+		return 32
+	}
+	return h.KeyLength
+}
+
+func (p passwordHash) derive(password string, keyLen int) ([]byte, error) {
+	switch p.algorithm {
+	case PasswordPBKDF2:
+		if p.iterations < 1 {
+			return nil, errors.New("PBKDF2 iterations must be positive")
+		}
+		return pbkdf2Key([]byte(password), p.salt, p.iterations, keyLen, sha256.New), nil
+	case PasswordScrypt:
+		return scryptKey([]byte(password), p.salt, 1<<p.logN, p.r, p.p, keyLen, sha256.New)
+	default:
+		return nil, fmt.Errorf("Unsupported password hash algorithm %q", p.algorithm)
+	}
+}
+
+func (p passwordHash) String() string {
+	var params string
+	if p.algorithm == PasswordScrypt {
+		params = fmt.Sprintf("ln=%d,r=%d,p=%d", p.logN, p.r, p.p)
+	} else {
+		params = fmt.Sprintf("i=%d", p.iterations)
+	}
+	return fmt.Sprintf("$%s$%s$%s$%s", p.algorithm, params,
+		base64.RawStdEncoding.EncodeToString(p.salt), base64.RawStdEncoding.EncodeToString(p.key))
+}
+
+func parsePasswordHash(encoded string) (passwordHash, error) {
+	invalid := errors.New("Password hash is badly formed")
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 5 || parts[0] != "" {
+		return passwordHash{}, invalid
+	}
+	p := passwordHash{algorithm: parts[1]}
+	for _, param := range strings.Split(parts[2], ",") {
+		key, value, _ := strings.Cut(param, "=")
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 {
+			return passwordHash{}, invalid
+		}
+		switch key {
+		case "i":
+			p.iterations = n
+		case "ln":
+			p.logN = n
+		case "r":
+			p.r = n
+		case "p":
+			p.p = n
+		default:
+			return passwordHash{}, invalid
+		}
+	}
+	switch p.algorithm {
+	case PasswordPBKDF2:
+		if p.iterations == 0 || p.iterations > maxPBKDF2Iterations {
+			return passwordHash{}, invalid
+		}
+	case PasswordScrypt:
+		if p.logN == 0 || p.logN > maxScryptLogN || p.r == 0 || p.r > maxScryptR || p.p == 0 || p.p > maxScryptP {
+			return passwordHash{}, invalid
+		}
+	default:
+		return passwordHash{}, fmt.Errorf("Unsupported password hash algorithm %q", p.algorithm)
+	}
+	var err error
+	if p.salt, err = base64.RawStdEncoding.DecodeString(parts[3]); err != nil {
+		return passwordHash{}, invalid
+	}
+	if p.key, err = base64.RawStdEncoding.DecodeString(parts[4]); err != nil || len(p.key) == 0 || len(p.key) > maxPasswordKeyLen {
+		return passwordHash{}, invalid
+	}
+	return p, nil
+}
+
+func (t *Tools) HashPassword(password string) (string, error) {
+	return t.passwordHasher.Hash(password)
+}
+
+func (t *Tools) VerifyPassword(password, encoded string) (bool, error) {
+	return t.passwordHasher.Verify(password, encoded)
+}
+
+func (t *Tools) PasswordNeedsRehash(encoded string) bool {
+	return t.passwordHasher.NeedsRehash(encoded)
+}
+
+// PasswordStrength scores a password from 0 (very weak) to 4 (strong).
+// Reasons explains what lowered the score.
+type PasswordStrength struct {
+	Score   int
+	Entropy float64
+	Reasons []string
+}
+
+var commonPasswords = map[string]bool{
+	"123456": true, "123456789": true, "12345678": true, "12345": true, "1234567": true,
+	"1234567890": true, "password": true, "password1": true, "qwerty": true, "qwerty123": true,
+	"abc123": true, "111111": true, "123123": true, "000000": true, "iloveyou": true,
+	"admin": true, "welcome": true, "monkey": true, "dragon": true, "letmein": true,
+	"football": true, "baseball": true, "sunshine": true, "princess": true, "master": true,
+	"shadow": true, "superman": true, "trustno1": true, "passw0rd": true, "starwars": true,
+	"whatever": true, "qwertyuiop": true, "asdfghjkl": true, "zxcvbnm": true, "1q2w3e4r": true,
+	"654321": true, "666666": true, "121212": true, "login": true, "hello": true,
+	"freedom": true, "michael": true, "charlie": true, "jordan": true, "secret": true,
+}
+
+var passwordSequences = []string{
+	"abcdefghijklmnopqrstuvwxyz",
+	"01234567890",
+	"qwertyuiop",
+	"asdfghjkl",
+	"zxcvbnm",
+}
+
+// EstimatePasswordStrength estimates the strength of a password from its
+// length and character classes, and penalizes common passwords, repeated
+// characters, keyboard or alphabetical sequences and words taken from
+// userInputs such as the user name or email address.
+func EstimatePasswordStrength(password string, userInputs ...string) PasswordStrength {
+	var s PasswordStrength
+	lower := strings.ToLower(password)
+	length := len([]rune(password))
+
+	var hasLower, hasUpper, hasDigit, hasSymbol, hasOther bool
+	for _, c := range password {
+		switch {
+		case c >= 'a' && c <= 'z':
+			hasLower = true
+		case c >= 'A' && c <= 'Z':
+			hasUpper = true
+		case c >= '0' && c <= '9':
+			hasDigit = true
+		case c < unicode.MaxASCII && unicode.IsPrint(c):
+			hasSymbol = true
+		default:
+			hasOther = true
+		}
+	}
+	pool, classes := 0, 0
+	for _, class := range []struct {
+		present bool
+		size    int
+	}{{hasLower, 26}, {hasUpper, 26}, {hasDigit, 10}, {hasSymbol, 33}, {hasOther, 100}} {
+		if class.present {
+			pool += class.size
+			classes++
+		}
+	}
+	if pool > 0 {
+		s.Entropy = float64(length) * math.Log2(float64(pool))
+	}
+
+	if length < 8 {
+		s.Reasons = append(s.Reasons, "Password is shorter than 8 characters")
+	}
+	if commonPasswords[lower] {
+		s.Reasons = append(s.Reasons, "Password is a commonly used password")
+		s.Entropy = 0
+	}
+	if hasRepeatedRun(password, 3) {
+		s.Reasons = append(s.Reasons, "Password contains repeated characters")
+		s.Entropy *= 0.75
+	}
+	if hasSequence(lower, 4) {
+		s.Reasons = append(s.Reasons, "Password contains a keyboard or alphabetical sequence")
+		s.Entropy *= 0.75
+	}
+	if containsUserInput(lower, userInputs) {
+		s.Reasons = append(s.Reasons, "Password contains personal information")
+		s.Entropy *= 0.5
+	}
+	if classes == 1 {
+		s.Reasons = append(s.Reasons, "Password uses a single kind of character")
+	}
+
+	switch {
+	case s.Entropy < 28:
+		s.Score = 0
+	case s.Entropy < 36:
+		s.Score = 1
+	case s.Entropy < 60:
+		s.Score = 2
+	case s.Entropy < 80:
+		s.Score = 3
+	default:
+		s.Score = 4
+	}
+	if length < 8 && s.Score > 1 {
+		s.Score = 1
+	}
+	return s
+}
+
+func containsUserInput(password string, userInputs []string) bool {
+	for _, input := range userInputs {
+		words := strings.FieldsFunc(strings.ToLower(input), func(c rune) bool {
+			return !unicode.IsLetter(c) && !unicode.IsDigit(c)
+		})
+		for _, word := range words {
+			if len(word) >= 3 && strings.Contains(password, word) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func hasRepeatedRun(s string, n int) bool {
+	run := 0
+	var previous rune
+	for i, c := range s {
+		if i > 0 && c == previous {
+			run++
+		} else {
+			run = 1
+		}
+		if run >= n {
+			return true
+		}
+		previous = c
+	}
+	return false
+}
+
+func hasSequence(s string, n int) bool {
+	if len(s) < n {
+		return false
+	}
+	for i := 0; i+n <= len(s); i++ {
+		chunk := s[i : i+n]
+		reversed := reverseString(chunk)
+		for _, seq := range passwordSequences {
+			if strings.Contains(seq, chunk) || strings.Contains(seq, reversed) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func reverseString(s string) string {
+	r := []rune(s)
+	for i, j := 0, len(r)-1; i < j; i, j = i+1, j-1 {
+		r[i], r[j] = r[j], r[i]
+	}
+	return string(r)
+}
@@ -0,0 +1,149 @@
+package toolkit
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+	"testing"
+)
+
+var pbkdf2Tests = []struct {
+	password   string
+	salt       string
+	iterations int
+	expected   string
+}{
+	{"password", "salt", 1, "120fb6cffcf8b32c43e7225256c4f837a86548c92ccc35480805987cb70be17b"},
+	{"password", "salt", 2, "ae4d0c95af6b46d32d0adff928f06dd02a303f8ef3c251dfd6e2d85a95474c43"},
+	{"password", "salt", 4096, "c5e478d59288c841aa530db6845c4c8d962893a001ce4e11a4963873aa98134a"},
+}
+
+func TestPBKDF2(t *testing.T) {
+	for _, e := range pbkdf2Tests {
+		key := pbkdf2Key([]byte(e.password), []byte(e.salt), e.iterations, 32, sha256.New)
+		if hex.EncodeToString(key) != e.expected {
+			t.Errorf("PBKDF2 with %d iterations gave %x", e.iterations, key)
+		}
+	}
+}
+
+var scryptTests = []struct {
+	password string
+	salt     string
+	n, r, p  int
+	expected string
+}{
+	{"", "", 16, 1, 1, "77d6576238657b203b19ca42c18a0497f16b4844e3074ae8dfdffa3fede21442fcd0069ded0948f8326a753a0fc81f17e8d3e0fb2e0d3628cf35e20c38d18906"},
+	{"password", "NaCl", 1024, 8, 16, "fdbabe1c9d3472007856e7190d01e9fe7c6ad7cbc8237830e77376634b3731622eaf30d92e22a3886ff109279d9830dac727afb94a83ee6d8360cbdfa2cc0640"},
+}
+
+func TestScrypt(t *testing.T) {
+	for _, e := range scryptTests {
+		key, err := scryptKey([]byte(e.password), []byte(e.salt), e.n, e.r, e.p, 64, sha256.New)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if hex.EncodeToString(key) != e.expected {
+			t.Errorf("scrypt with N=%d gave %x", e.n, key)
+		}
+	}
+	if _, err := scryptKey(nil, nil, 15, 1, 1, 32, sha256.New); err == nil {
+		t.Error("Error expected when N is not a power of 2, but got none.")
+	}
+}
+
+var hasherTests = []struct {
+	name   string
+	hasher PasswordHasher
+	prefix string
+}{
+	{
+		name:   "pbkdf2",
+		hasher: PasswordHasher{Iterations: 1000},
+		prefix: "$pbkdf2-sha256$i=1000$",
+	},
+	{
+		name:   "scrypt",
+		hasher: PasswordHasher{Algorithm: PasswordScrypt, ScryptLogN: 10, ScryptR: 8, ScryptP: 1},
+		prefix: "$scrypt$ln=10,r=8,p=1$",
+	},
+}
+
+func TestPasswordHasher(t *testing.T) {
+	for _, e := range hasherTests {
+		encoded, err := e.hasher.Hash("correct horse battery staple")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.HasPrefix(encoded, e.prefix) {
+			t.Errorf("%s - Unexpected encoded hash %s", e.name, encoded)
+		}
+		ok, err := e.hasher.Verify("correct horse battery staple", encoded)
+		if err != nil || !ok {
+			t.Errorf("%s - Password should verify: %v", e.name, err)
+		}
+		ok, err = e.hasher.Verify("wrong password", encoded)
+		if err != nil || ok {
+			t.Errorf("%s - Wrong password should not verify: %v", e.name, err)
+		}
+		if e.hasher.NeedsRehash(encoded) {
+			t.Errorf("%s - Hash should not need a rehash with the same parameters", e.name)
+		}
+	}
+
+	old, _ := PasswordHasher{Iterations: 1000}.Hash("secret")
+	if !(PasswordHasher{Iterations: 2000}).NeedsRehash(old) {
+		t.Error("Hash should need a rehash when iterations are upgraded")
+	}
+	if !(PasswordHasher{Algorithm: PasswordScrypt}).NeedsRehash(old) {
+		t.Error("Hash should need a rehash when the algorithm changes")
+	}
+	ok, err := PasswordHasher{Algorithm: PasswordScrypt}.Verify("secret", old)
+	if err != nil || !ok {
+		t.Errorf("Old hash should still verify after an upgrade: %v", err)
+	}
+
+	for _, encoded := range []string{
+		"",
+		"$md5$x$y$z",
+		"$pbkdf2-sha256$i=0$c2FsdA$a2V5",
+		"$scrypt$ln=40,r=8,p=1$c2FsdA$a2V5",
+		"$scrypt$ln=22,r=1000000,p=1000$c2FsdA$a2V5",
+		"$scrypt$ln=10,r=8,p=500000000$c2FsdA$a2V5",
+		"$pbkdf2-sha256$i=1000$c2FsdA$" + strings.Repeat("a2V5", 100),
+	} {
+		if _, err := (PasswordHasher{}).Verify("secret", encoded); err == nil {
+			t.Errorf("Error expected for %q, but got none.", encoded)
+		}
+	}
+}
+
+var strengthTests = []struct {
+	password   string
+	userInputs []string
+	maxScore   int
+	minScore   int
+	reason     string
+}{
+	{password: "password", maxScore: 0, reason: "commonly used"},
+	{password: "abc", maxScore: 0, reason: "shorter than 8"},
+	{password: "aaaaaaaaaaaa", maxScore: 2, reason: "repeated"},
+	{password: "qwertyZX91!", maxScore: 3, reason: "sequence"},
+	{password: "alice1987!Rr", userInputs: []string{"alice@example.com"}, maxScore: 2, reason: "personal"},
+	{password: "7hY#kq2&Lm9!pW4z", minScore: 4},
+}
+
+func TestEstimatePasswordStrength(t *testing.T) {
+	for _, e := range strengthTests {
+		s := EstimatePasswordStrength(e.password, e.userInputs...)
+		if s.Score > e.maxScore && e.reason != "" {
+			t.Errorf("%s - Score %d is higher than %d", e.password, s.Score, e.maxScore)
+		}
+		if s.Score < e.minScore {
+			t.Errorf("%s - Score %d is lower than %d", e.password, s.Score, e.minScore)
+		}
+		if e.reason != "" && !strings.Contains(strings.Join(s.Reasons, "; "), e.reason) {
+			t.Errorf("%s - Expected a reason containing %q, got %v", e.password, e.reason, s.Reasons)
+		}
+	}
+}
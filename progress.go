@@ -0,0 +1,225 @@
+package toolkit
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// UploadProgress describes how far an upload has gone. While the request body
+// is being received FileName is empty; once the files are written to storage
+// FileName and PartBytes describe the file currently being written.
+type UploadProgress struct {
+	UploadID  string  `json:"upload_id"`
+	FileName  string  `json:"file_name,omitempty"`
+	PartBytes int64   `json:"part_bytes"`
+	Received  int64   `json:"received"`
+	Total     int64   `json:"total"`
+	Rate      float64 `json:"rate"`
+	Done      bool    `json:"done"`
+	Error     string  `json:"error,omitempty"`
+}
+
+// ProgressTracker keeps the latest progress of every upload carrying an ID in
+// the X-Upload-ID header or the upload_id query parameter, and serves it as
+// JSON so that browsers can poll it. Finished uploads are kept for Retention.
+// The zero value is ready to use, though NewProgressTracker also sets a
+// Retention of a minute and makes ServeHTTP write errors as t would.
+type ProgressTracker struct {
+	Retention time.Duration
+
+	tools    *Tools
+	mu       sync.Mutex
+	uploads  map[string]UploadProgress
+	finished map[string]time.Time
+}
+
+func (t *Tools) NewProgressTracker() *ProgressTracker {
+	return &ProgressTracker{
+		Retention: time.Minute,
+		tools:     t,
+	}
+}
+
+func (pt *ProgressTracker) Get(id string) (UploadProgress, bool) {
+	pt.mu.Lock()
+	defer pt.mu.Unlock()
+	p, ok := pt.uploads[id]
+	return p, ok
+}
+
+func (pt *ProgressTracker) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	t := pt.tools
+	if t == nil {
+		t = &Tools{}
+	}
+	p, ok := pt.Get(r.URL.Query().Get("id"))
+	if !ok {
+		_ = t.ErrorJSON(w, errors.New("Upload not found"), http.StatusNotFound)
+		return
+	}
+	_ = t.WriteJSON(w, http.StatusOK, p, http.Header{"Cache-Control": {"no-store"}})
+}
+
+func (pt *ProgressTracker) update(p UploadProgress) {
+	pt.mu.Lock()
+	defer pt.mu.Unlock()
+	if pt.uploads == nil {
+		pt.uploads = make(map[string]UploadProgress)
+		pt.finished = make(map[string]time.Time)
+	}
+	now := time.Now()
+	for id, at := range pt.finished {
+		if now.Sub(at) > pt.Retention {
+			delete(pt.uploads, id)
+			delete(pt.finished, id)
+		}
+	}
+	pt.uploads[p.UploadID] = p
+	if p.Done {
+		pt.finished[p.UploadID] = now
+	}
+}
+
+type progressReporter struct {
+	callback func(UploadProgress)
+	tracker  *ProgressTracker
+	start    time.Time
+
+	mu       sync.Mutex
+	progress UploadProgress
+}
+
+func (t *Tools) newProgressReporter(r *http.Request) *progressReporter {
+	id := r.Header.Get("X-Upload-ID")
+	if id == "" {
+		id = r.URL.Query().Get("upload_id")
+	}
+	tracker := t.progressTracker
+	if id == "" {
+		tracker = nil
+	}
+	if t.onUploadProgress == nil && tracker == nil {
+		return nil
+	}
+	return &progressReporter{
+		callback: t.onUploadProgress,
+		tracker:  tracker,
+		start:    time.Now(),
+		progress: UploadProgress{UploadID: id, Total: r.ContentLength},
+	}
+}
+
+func (p *progressReporter) received(n int) {
+	if p == nil || n == 0 {
+		return
+	}
+	p.report(func(progress *UploadProgress) {
+		progress.Received += int64(n)
+	})
+}
+
+func (p *progressReporter) written(fileName string, n int) {
+	if p == nil {
+		return
+	}
+	p.report(func(progress *UploadProgress) {
+		if progress.FileName != fileName {
+			progress.FileName = fileName
+			progress.PartBytes = 0
+		}
+		progress.PartBytes += int64(n)
+	})
+}
+
+func (p *progressReporter) done(err error) {
+	if p == nil {
+		return
+	}
+	p.report(func(progress *UploadProgress) {
+		progress.Done = true
+		if err != nil {
+			progress.Error = err.Error()
+		}
+	})
+}
+
+func (p *progressReporter) report(update func(*UploadProgress)) {
+	p.mu.Lock()
+	update(&p.progress)
+	if elapsed := time.Since(p.start).Seconds(); elapsed > 0 {
+		p.progress.Rate = float64(p.progress.Received) / elapsed
+	}
+	progress := p.progress
+	p.mu.Unlock()
+
+	if p.callback != nil {
+		p.callback(progress)
+	}
+	if p.tracker != nil {
+		p.tracker.update(progress)
+	}
+}
+
+type progressBody struct {
+	io.ReadCloser
+	progress *progressReporter
+}
+
+func (b *progressBody) Read(buff []byte) (int, error) {
+	n, err := b.ReadCloser.Read(buff)
+	b.progress.received(n)
+	return n, err
+}
+
+// uploadWriter reports every write to the progress reporter and, when rate is
+// positive, sleeps so that writes never exceed rate bytes per second. A single
+// throttle is shared by all the files of a request.
+type uploadWriter struct {
+	w        io.Writer
+	ctx      context.Context
+	fileName string
+	progress *progressReporter
+	throttle *uploadThrottle
+}
+
+type uploadThrottle struct {
+	rate    int64
+	start   time.Time
+	written int64
+}
+
+func (u *uploadWriter) Write(buff []byte) (int, error) {
+	n, err := u.w.Write(buff)
+	u.progress.written(u.fileName, n)
+	if err != nil {
+		return n, err
+	}
+	return n, u.throttle.wait(u.ctx, n)
+}
+
+func (th *uploadThrottle) wait(ctx context.Context, n int) error {
+	if th == nil || th.rate <= 0 {
+		return nil
+	}
+	if th.start.IsZero() {
+		th.start = time.Now()
+	}
+	th.written += int64(n)
+	expected := time.Duration(float64(th.written) / float64(th.rate) * float64(time.Second))
+	delay := expected - time.Since(th.start)
+	if delay <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,84 @@
+package toolkit
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestTools_UploadFilesProgress(t *testing.T) {
+	var testTools Tools
+	testTools.progressTracker = testTools.NewProgressTracker()
+	var updates []UploadProgress
+	testTools.onUploadProgress = func(p UploadProgress) {
+		updates = append(updates, p)
+	}
+	content := bytes.Repeat([]byte("a"), 4096)
+	request := newMultipartRequest(t, map[string][]byte{"a.txt": content}, "a.txt")
+	request.Header.Set("X-Upload-ID", "abc")
+
+	if _, err := testTools.UploadFiles(request, t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	if len(updates) == 0 {
+		t.Fatal("No progress reported")
+	}
+	last := updates[len(updates)-1]
+	if !last.Done || last.Received != request.ContentLength || last.Total != request.ContentLength {
+		t.Errorf("Unexpected final progress: %+v", last)
+	}
+	if last.FileName != "a.txt" || last.PartBytes != int64(len(content)) {
+		t.Errorf("Unexpected part progress: %+v", last)
+	}
+
+	rr := httptest.NewRecorder()
+	testTools.progressTracker.ServeHTTP(rr, httptest.NewRequest("GET", "/progress?id=abc", nil))
+	var payload UploadProgress
+	if err := json.NewDecoder(rr.Body).Decode(&payload); err != nil {
+		t.Fatal(err)
+	}
+	if payload != last {
+		t.Errorf("Tracker returned %+v, expected %+v", payload, last)
+	}
+
+	rr = httptest.NewRecorder()
+	testTools.progressTracker.ServeHTTP(rr, httptest.NewRequest("GET", "/progress?id=unknown", nil))
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("Wrong status code for unknown upload, expected 404 but got %d", rr.Code)
+	}
+}
+
+func TestProgressTracker_ZeroValue(t *testing.T) {
+	testTools := Tools{progressTracker: &ProgressTracker{Retention: time.Minute}}
+	request := newMultipartRequest(t, map[string][]byte{"a.txt": []byte("hello")}, "a.txt")
+	request.Header.Set("X-Upload-ID", "abc")
+	if _, err := testTools.UploadFiles(request, t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+
+	for id, status := range map[string]int{"abc": http.StatusOK, "unknown": http.StatusNotFound} {
+		rr := httptest.NewRecorder()
+		testTools.progressTracker.ServeHTTP(rr, httptest.NewRequest("GET", "/progress?id="+id, nil))
+		if rr.Code != status {
+			t.Errorf("%s: expected status %d, got %d", id, status, rr.Code)
+		}
+	}
+}
+
+func TestTools_UploadFilesThrottle(t *testing.T) {
+	var testTools Tools
+	testTools.uploadRateLimit = 10000
+	content := bytes.Repeat([]byte("a"), 2000)
+	request := newMultipartRequest(t, map[string][]byte{"a.txt": content}, "a.txt")
+
+	start := time.Now()
+	if _, err := testTools.UploadFiles(request, t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed < 150*time.Millisecond {
+		t.Errorf("Upload was not throttled, took %s", elapsed)
+	}
+}
@@ -0,0 +1,199 @@
+package toolkit
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// QuotaLimit is the maximum usage allowed for a key. A zero field means no
+// limit.
+type QuotaLimit struct {
+	MaxBytes int64
+	MaxFiles int64
+}
+
+type QuotaUsage struct {
+	Bytes int64 `json:"bytes"`
+	Files int64 `json:"files"`
+}
+
+type QuotaStore interface {
+	Get(key string) (QuotaUsage, error)
+	Set(key string, usage QuotaUsage) error
+}
+
+// Quota tracks upload usage per owner, as returned by OwnerKey, and per upload
+// directory. Store defaults to an in-memory FileQuotaStore.
+type Quota struct {
+	OwnerKey   func(r *http.Request) string
+	OwnerLimit QuotaLimit
+	DirLimit   QuotaLimit
+	Store      QuotaStore
+
+	mu sync.Mutex
+}
+
+type QuotaExceededError struct {
+	Key   string
+	Limit QuotaLimit
+	Usage QuotaUsage
+}
+
+func (e *QuotaExceededError) Error() string {
+	return fmt.Sprintf("Upload quota exceeded for %s", e.Key)
+}
+
+func (q *Quota) Owner(r *http.Request) string {
+	if q.OwnerKey == nil {
+		return ""
+	}
+	return q.OwnerKey(r)
+}
+
+func (q *Quota) OwnerUsage(owner string) (QuotaUsage, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.store().Get(quotaOwnerKey(owner))
+}
+
+func (q *Quota) DirUsage(dir string) (QuotaUsage, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.store().Get(quotaDirKey(dir))
+}
+
+// Reserve accounts for one file of the given size, or returns a
+// *QuotaExceededError if it would take the owner or the directory over its
+// limit.
+func (q *Quota) Reserve(owner, dir string, size int64) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	keys, limits := q.keys(owner, dir)
+	usages := make([]QuotaUsage, len(keys))
+	for i, key := range keys {
+		usage, err := q.store().Get(key)
+		if err != nil {
+			return err
+		}
+		usage.Bytes += size
+		usage.Files++
+		if (limits[i].MaxBytes > 0 && usage.Bytes > limits[i].MaxBytes) ||
+			(limits[i].MaxFiles > 0 && usage.Files > limits[i].MaxFiles) {
+			return &QuotaExceededError{Key: key, Limit: limits[i], Usage: usage}
+		}
+		usages[i] = usage
+	}
+	for i, key := range keys {
+		if err := q.store().Set(key, usages[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Release gives back what Reserve took, for instance when an upload fails or a
+// stored file is deleted.
+func (q *Quota) Release(owner, dir string, size int64) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	keys, _ := q.keys(owner, dir)
+	for _, key := range keys {
+		usage, err := q.store().Get(key)
+		if err != nil {
+			return err
+		}
+		usage.Bytes = max(usage.Bytes-size, 0)
+		usage.Files = max(usage.Files-1, 0)
+		if err = q.store().Set(key, usage); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (q *Quota) keys(owner, dir string) ([]string, []QuotaLimit) {
+	keys := []string{quotaDirKey(dir)}
+	limits := []QuotaLimit{q.DirLimit}
+	if owner != "" {
+		keys = append(keys, quotaOwnerKey(owner))
+		limits = append(limits, q.OwnerLimit)
+	}
+	return keys, limits
+}
+
+// store must be called with q.mu held.
+func (q *Quota) store() QuotaStore {
+	if q.Store == nil {
+		q.Store = &FileQuotaStore{}
+	}
+	return q.Store
+}
+
+func quotaOwnerKey(owner string) string {
+	return "owner:" + owner
+}
+
+func quotaDirKey(dir string) string {
+	return "dir:" + filepath.Clean(dir)
+}
+
+// FileQuotaStore keeps usage in memory and, when Path is set, persists it as a
+// JSON file rewritten atomically on every change.
+type FileQuotaStore struct {
+	Path string
+
+	mu     sync.Mutex
+	usages map[string]QuotaUsage
+}
+
+func NewFileQuotaStore(path string) (*FileQuotaStore, error) {
+	s := &FileQuotaStore{Path: path, usages: make(map[string]QuotaUsage)}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return s, nil
+		}
+		return nil, err
+	}
+	if err = json.Unmarshal(data, &s.usages); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *FileQuotaStore) Get(key string) (QuotaUsage, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.usages[key], nil
+}
+
+func (s *FileQuotaStore) Set(key string, usage QuotaUsage) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.usages == nil {
+		s.usages = make(map[string]QuotaUsage)
+	}
+	if usage == (QuotaUsage{}) {
+		delete(s.usages, key)
+	} else {
+		s.usages[key] = usage
+	}
+	if s.Path == "" {
+		return nil
+	}
+	data, err := json.Marshal(s.usages)
+	if err != nil {
+		return err
+	}
+	tmp := s.Path + ".tmp"
+	if err = os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.Path)
+}
@@ -0,0 +1,121 @@
+package toolkit
+
+import (
+	"errors"
+	"net/http"
+	"path/filepath"
+	"testing"
+)
+
+var quotaTests = []struct {
+	name          string
+	ownerLimit    QuotaLimit
+	dirLimit      QuotaLimit
+	owner         string
+	sizes         []int64
+	errorExpected bool
+}{
+	{
+		name:       "within limits",
+		ownerLimit: QuotaLimit{MaxBytes: 100, MaxFiles: 2},
+		owner:      "alice",
+		sizes:      []int64{50, 50},
+	},
+	{
+		name:          "owner bytes exceeded",
+		ownerLimit:    QuotaLimit{MaxBytes: 100},
+		owner:         "alice",
+		sizes:         []int64{50, 51},
+		errorExpected: true,
+	},
+	{
+		name:          "owner files exceeded",
+		ownerLimit:    QuotaLimit{MaxFiles: 1},
+		owner:         "alice",
+		sizes:         []int64{1, 1},
+		errorExpected: true,
+	},
+	{
+		name:          "directory exceeded without owner",
+		dirLimit:      QuotaLimit{MaxBytes: 10},
+		sizes:         []int64{5, 6},
+		errorExpected: true,
+	},
+}
+
+func TestQuota_Reserve(t *testing.T) {
+	for _, e := range quotaTests {
+		q := Quota{OwnerLimit: e.ownerLimit, DirLimit: e.dirLimit}
+		var err error
+		for _, size := range e.sizes {
+			if err = q.Reserve(e.owner, "./uploads", size); err != nil {
+				break
+			}
+		}
+		var quotaErr *QuotaExceededError
+		if e.errorExpected && !errors.As(err, &quotaErr) {
+			t.Errorf("%s - Expected a QuotaExceededError, got %v", e.name, err)
+		}
+		if !e.errorExpected && err != nil {
+			t.Errorf("%s - Error not expected, but got one : %s", e.name, err)
+		}
+	}
+}
+
+func TestFileQuotaStore_Persist(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "quota.json")
+	store, err := NewFileQuotaStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := Quota{Store: store}
+	if err = q.Reserve("alice", "./uploads", 10); err != nil {
+		t.Fatal(err)
+	}
+	if err = q.Reserve("alice", "./uploads", 20); err != nil {
+		t.Fatal(err)
+	}
+	if err = q.Release("alice", "./uploads", 10); err != nil {
+		t.Fatal(err)
+	}
+
+	store, err = NewFileQuotaStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q = Quota{Store: store}
+	usage, err := q.OwnerUsage("alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if usage != (QuotaUsage{Bytes: 20, Files: 1}) {
+		t.Errorf("Unexpected owner usage after reload: %+v", usage)
+	}
+	usage, _ = q.DirUsage("uploads")
+	if usage != (QuotaUsage{Bytes: 20, Files: 1}) {
+		t.Errorf("Unexpected directory usage after reload: %+v", usage)
+	}
+}
+
+func TestTools_UploadFilesQuota(t *testing.T) {
+	uploadDir := t.TempDir()
+	var testTools Tools
+	testTools.uploadAllOrNothing = true
+	testTools.quota = &Quota{
+		OwnerKey:   func(r *http.Request) string { return r.Header.Get("X-User") },
+		OwnerLimit: QuotaLimit{MaxFiles: 2},
+	}
+	files := map[string][]byte{"a.txt": []byte("a"), "b.txt": []byte("b"), "c.txt": []byte("c")}
+	request := newMultipartRequest(t, files, "a.txt", "b.txt", "c.txt")
+	request.Header.Set("X-User", "alice")
+
+	_, err := testTools.UploadFiles(request, uploadDir)
+	var quotaErr *QuotaExceededError
+	if !errors.As(err, &quotaErr) {
+		t.Fatalf("Expected a QuotaExceededError, got %v", err)
+	}
+	usage, _ := testTools.quota.OwnerUsage("alice")
+	if usage != (QuotaUsage{}) {
+		t.Errorf("Usage should be released after rollback, got %+v", usage)
+	}
+}
@@ -0,0 +1,361 @@
+package toolkit
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"net"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const defaultRateLimitMaxKeys = 10000
+
+// Limiter decides whether one more request may be made for a key.
+// Implementations must be safe for concurrent use.
+type Limiter interface {
+	Allow(key string) RateLimitResult
+}
+
+type RateLimitResult struct {
+	Allowed   bool
+	Limit     int
+	Remaining int
+	// Reset is the time until the limit is fully restored.
+	Reset time.Duration
+	// RetryAfter is the time until a denied request may be retried.
+	RetryAfter time.Duration
+}
+
+// TokenBucketLimiter allows bursts of up to Burst requests per key, refilled
+// at Rate requests per second. Keys that have been idle long enough for their
+// bucket to be full are forgotten, and at most MaxKeys keys, 10000 by default,
+// are kept in memory, evicting the least recently seen ones.
+//
+// Rate and Burst must be positive: Allow panics otherwise, rather than let
+// every request through. NewTokenBucketLimiter reports them as an error.
+type TokenBucketLimiter struct {
+	Rate    float64
+	Burst   int
+	MaxKeys int
+
+	mu      sync.Mutex
+	buckets map[string]*tokenBucket
+	now     func() time.Time
+}
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+func NewTokenBucketLimiter(rate float64, burst int) (*TokenBucketLimiter, error) {
+	l := &TokenBucketLimiter{Rate: rate, Burst: burst}
+	if err := l.validate(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+func (l *TokenBucketLimiter) validate() error {
+	if !(l.Rate > 0) || math.IsInf(l.Rate, 1) {
+		return errors.New("TokenBucketLimiter Rate must be a positive number")
+	}
+	if l.Burst < 1 {
+		return errors.New("TokenBucketLimiter Burst must be positive")
+	}
+	return nil
+}
+
+func (l *TokenBucketLimiter) Allow(key string) RateLimitResult {
+	if err := l.validate(); err != nil {
+		panic(err)
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := limiterNow(l.now)
+	if l.buckets == nil {
+		l.buckets = make(map[string]*tokenBucket)
+	}
+	burst := float64(l.Burst)
+	b, ok := l.buckets[key]
+	if !ok {
+		evictLimiterKeys(l.buckets, l.MaxKeys, func(b *tokenBucket) (bool, time.Time) {
+			return b.tokens+now.Sub(b.last).Seconds()*l.Rate >= burst, b.last
+		})
+		b = &tokenBucket{tokens: burst, last: now}
+		l.buckets[key] = b
+	}
+	b.tokens = math.Min(burst, b.tokens+now.Sub(b.last).Seconds()*l.Rate)
+	b.last = now
+
+	result := RateLimitResult{Limit: l.Burst}
+	if b.tokens >= 1 {
+		b.tokens--
+		result.Allowed = true
+	} else {
+		result.RetryAfter = rateDuration(1-b.tokens, l.Rate)
+	}
+	result.Remaining = int(b.tokens)
+	result.Reset = rateDuration(burst-b.tokens, l.Rate)
+	return result
+}
+
+func rateDuration(tokens, rate float64) time.Duration {
+	if rate <= 0 {
+		return time.Duration(math.MaxInt64)
+	}
+	return time.Duration(tokens / rate * float64(time.Second))
+}
+
+// SlidingWindowLimiter allows Limit requests per key in any Window, estimated
+// from the counts of the current and the previous fixed windows. Keys are
+// evicted as by TokenBucketLimiter, and Limit and Window must be positive as
+// its Rate and Burst are.
+type SlidingWindowLimiter struct {
+	Limit   int
+	Window  time.Duration
+	MaxKeys int
+
+	mu      sync.Mutex
+	windows map[string]*slidingWindow
+	now     func() time.Time
+}
+
+type slidingWindow struct {
+	start    time.Time
+	previous int
+	current  int
+}
+
+func NewSlidingWindowLimiter(limit int, window time.Duration) (*SlidingWindowLimiter, error) {
+	l := &SlidingWindowLimiter{Limit: limit, Window: window}
+	if err := l.validate(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+func (l *SlidingWindowLimiter) validate() error {
+	if l.Limit < 1 {
+		return errors.New("SlidingWindowLimiter Limit must be positive")
+	}
+	if l.Window <= 0 {
+		return errors.New("SlidingWindowLimiter Window must be positive")
+	}
+	return nil
+}
+
+func (l *SlidingWindowLimiter) Allow(key string) RateLimitResult {
+	if err := l.validate(); err != nil {
+		panic(err)
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := limiterNow(l.now)
+	if l.windows == nil {
+		l.windows = make(map[string]*slidingWindow)
+	}
+	start := now.Truncate(l.Window)
+	w, ok := l.windows[key]
+	if !ok {
+		evictLimiterKeys(l.windows, l.MaxKeys, func(w *slidingWindow) (bool, time.Time) {
+			return now.Sub(w.start) >= 2*l.Window, w.start
+		})
+		w = &slidingWindow{start: start}
+		l.windows[key] = w
+	}
+	if !w.start.Equal(start) {
+		if start.Sub(w.start) == l.Window {
+			w.previous = w.current
+		} else {
+			w.previous = 0
+		}
+		w.current = 0
+		w.start = start
+	}
+
+	elapsed := now.Sub(start)
+	weight := 1 - float64(elapsed)/float64(l.Window)
+	estimate := float64(w.previous)*weight + float64(w.current)
+	result := RateLimitResult{Limit: l.Limit, Reset: l.Window - elapsed}
+	if estimate+1 > float64(l.Limit) {
+		result.RetryAfter = l.Window - elapsed
+		if spare := float64(l.Limit - w.current - 1); spare >= 0 && w.previous > 0 {
+			// The previous window has to weigh little enough to leave room.
+			result.RetryAfter = time.Duration((1-spare/float64(w.previous))*float64(l.Window)) - elapsed
+		}
+		return result
+	}
+	w.current++
+	result.Allowed = true
+	result.Remaining = max(l.Limit-int(math.Ceil(estimate))-1, 0)
+	return result
+}
+
+func limiterNow(now func() time.Time) time.Time {
+	if now != nil {
+		return now()
+	}
+	return time.Now()
+}
+
+// evictLimiterKeys makes room for a new key: it drops the entries that idle
+// reports as no longer limiting anything and, if there are still maxKeys
+// entries, the least recently seen one.
+func evictLimiterKeys[S any](m map[string]S, maxKeys int, idle func(S) (bool, time.Time)) {
+	if maxKeys <= 0 {
+		maxKeys = defaultRateLimitMaxKeys
+	}
+	if len(m) < maxKeys {
+		return
+	}
+	var oldestKey string
+	var oldest time.Time
+	for key, entry := range m {
+		isIdle, seen := idle(entry)
+		if isIdle {
+			delete(m, key)
+			continue
+		}
+		if oldestKey == "" || seen.Before(oldest) {
+			oldestKey, oldest = key, seen
+		}
+	}
+	if len(m) >= maxKeys {
+		delete(m, oldestKey)
+	}
+}
+
+// RateLimit applies limiter to the requests for which key returns a non-empty
+// key. Every limited response carries RateLimit-Limit, RateLimit-Remaining and
+// RateLimit-Reset headers; denied requests get a 429 with a Retry-After header.
+func (t *Tools) RateLimit(limiter Limiter, key func(r *http.Request) string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		k := key(r)
+		if k == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		result := limiter.Allow(k)
+		h := w.Header()
+		h.Set("RateLimit-Limit", strconv.Itoa(result.Limit))
+		h.Set("RateLimit-Remaining", strconv.Itoa(result.Remaining))
+		h.Set("RateLimit-Reset", strconv.FormatInt(ceilSeconds(result.Reset), 10))
+		if !result.Allowed {
+			h.Set("Retry-After", strconv.FormatInt(max(ceilSeconds(result.RetryAfter), 1), 10))
+			t.log(r.Context(), slog.LevelInfo, "rate limited",
+				slog.String("method", r.Method),
+				slog.String("path", r.URL.Path))
+			_ = t.ErrorJSON(w, errors.New("Too many requests"), http.StatusTooManyRequests)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func ceilSeconds(d time.Duration) int64 {
+	return int64(math.Ceil(d.Seconds()))
+}
+
+// FirstKey returns a key function trying each of keys in turn, for instance
+// the user ID, then the API key, then the client IP.
+func FirstKey(keys ...func(r *http.Request) string) func(r *http.Request) string {
+	return func(r *http.Request) string {
+		for _, key := range keys {
+			if k := key(r); k != "" {
+				return k
+			}
+		}
+		return ""
+	}
+}
+
+// HeaderKey keys requests by the value of a header such as an API key. The
+// value is hashed so that secrets are not kept in memory.
+func HeaderKey(name string) func(r *http.Request) string {
+	return func(r *http.Request) string {
+		value := r.Header.Get(name)
+		if value == "" {
+			return ""
+		}
+		sum := sha256.Sum256([]byte(value))
+		return "header:" + hex.EncodeToString(sum[:16])
+	}
+}
+
+// TrustedProxies lists the networks of the reverse proxies whose
+// X-Forwarded-For headers can be believed.
+type TrustedProxies []netip.Prefix
+
+func ParseTrustedProxies(cidrs ...string) (TrustedProxies, error) {
+	proxies := make(TrustedProxies, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		if !strings.Contains(cidr, "/") {
+			addr, err := netip.ParseAddr(cidr)
+			if err != nil {
+				return nil, fmt.Errorf("Invalid trusted proxy %q", cidr)
+			}
+			proxies = append(proxies, netip.PrefixFrom(addr, addr.BitLen()))
+			continue
+		}
+		prefix, err := netip.ParsePrefix(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid trusted proxy %q", cidr)
+		}
+		proxies = append(proxies, prefix.Masked())
+	}
+	return proxies, nil
+}
+
+func (p TrustedProxies) contains(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	for _, prefix := range p {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// ClientIP returns the address of the client: the peer address, unless it is
+// a trusted proxy, in which case X-Forwarded-For is read from the right and
+// the first address that is not a trusted proxy is used.
+func (p TrustedProxies) ClientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return host
+	}
+	addr = addr.Unmap()
+	if !p.contains(addr) {
+		return addr.String()
+	}
+	hops := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop, err := netip.ParseAddr(strings.TrimSpace(hops[i]))
+		if err != nil {
+			break
+		}
+		addr = hop.Unmap()
+		if !p.contains(addr) {
+			break
+		}
+	}
+	return addr.String()
+}
+
+// Key keys requests by client IP.
+func (p TrustedProxies) Key(r *http.Request) string {
+	return "ip:" + p.ClientIP(r)
+}
@@ -0,0 +1,207 @@
+package toolkit
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+type fakeClock struct {
+	t time.Time
+}
+
+func (c *fakeClock) now() time.Time {
+	return c.t
+}
+
+func TestTokenBucketLimiter_Allow(t *testing.T) {
+	clock := &fakeClock{t: time.Unix(1700000000, 0)}
+	l := &TokenBucketLimiter{Rate: 1, Burst: 3, now: clock.now}
+
+	for i := 0; i < 3; i++ {
+		if result := l.Allow("a"); !result.Allowed || result.Remaining != 2-i {
+			t.Fatalf("request %d: unexpected result %+v", i, result)
+		}
+	}
+	result := l.Allow("a")
+	if result.Allowed || result.RetryAfter != time.Second {
+		t.Errorf("expected denial with a 1s retry, got %+v", result)
+	}
+	if !l.Allow("b").Allowed {
+		t.Error("keys must be limited separately")
+	}
+
+	clock.t = clock.t.Add(1500 * time.Millisecond)
+	if result = l.Allow("a"); !result.Allowed || result.Remaining != 0 {
+		t.Errorf("expected a refilled token, got %+v", result)
+	}
+	if result.Reset != 2500*time.Millisecond {
+		t.Errorf("expected reset in 2.5s, got %v", result.Reset)
+	}
+}
+
+func TestSlidingWindowLimiter_Allow(t *testing.T) {
+	clock := &fakeClock{t: time.Unix(1700000000, 0).Truncate(time.Minute)}
+	l := &SlidingWindowLimiter{Limit: 4, Window: time.Minute, now: clock.now}
+
+	for i := 0; i < 4; i++ {
+		if !l.Allow("a").Allowed {
+			t.Fatalf("request %d was denied", i)
+		}
+	}
+	if l.Allow("a").Allowed {
+		t.Fatal("fifth request in the window was allowed")
+	}
+
+	// A quarter into the next window the previous one still weighs 3 requests.
+	clock.t = clock.t.Add(75 * time.Second)
+	result := l.Allow("a")
+	if !result.Allowed || result.Remaining != 0 {
+		t.Fatalf("expected one more request, got %+v", result)
+	}
+	result = l.Allow("a")
+	if result.Allowed {
+		t.Fatal("expected denial")
+	}
+	// Room for one more once the previous window weighs 2: half way through.
+	if result.RetryAfter != 15*time.Second {
+		t.Errorf("expected retry in 15s, got %v", result.RetryAfter)
+	}
+
+	clock.t = clock.t.Add(3 * time.Minute)
+	if result = l.Allow("a"); !result.Allowed || result.Remaining != 3 {
+		t.Errorf("expected a fresh window, got %+v", result)
+	}
+}
+
+var limiterSettingsTests = []struct {
+	name    string
+	limiter func() (Limiter, error)
+	valid   bool
+}{
+	{name: "token bucket", limiter: func() (Limiter, error) { return NewTokenBucketLimiter(1, 3) }, valid: true},
+	{name: "zero rate", limiter: func() (Limiter, error) { return NewTokenBucketLimiter(0, 3) }},
+	{name: "infinite rate", limiter: func() (Limiter, error) { return NewTokenBucketLimiter(math.Inf(1), 3) }},
+	{name: "zero burst", limiter: func() (Limiter, error) { return NewTokenBucketLimiter(1, 0) }},
+	{name: "sliding window", limiter: func() (Limiter, error) { return NewSlidingWindowLimiter(4, time.Minute) }, valid: true},
+	{name: "zero window", limiter: func() (Limiter, error) { return NewSlidingWindowLimiter(4, 0) }},
+	{name: "negative window", limiter: func() (Limiter, error) { return NewSlidingWindowLimiter(4, -time.Second) }},
+	{name: "zero limit", limiter: func() (Limiter, error) { return NewSlidingWindowLimiter(0, time.Minute) }},
+}
+
+func TestLimiterSettings(t *testing.T) {
+	for _, e := range limiterSettingsTests {
+		l, err := e.limiter()
+		if (err == nil) != e.valid {
+			t.Errorf("%s: expected valid %v, got %v", e.name, e.valid, err)
+		}
+		if e.valid && !l.Allow("a").Allowed {
+			t.Errorf("%s: first request denied", e.name)
+		}
+	}
+
+	for _, l := range []Limiter{&TokenBucketLimiter{}, &SlidingWindowLimiter{Limit: 4}} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("%T: expected Allow to panic with invalid settings", l)
+				}
+			}()
+			l.Allow("a")
+		}()
+	}
+}
+
+func TestLimiterEviction(t *testing.T) {
+	clock := &fakeClock{t: time.Unix(1700000000, 0)}
+	l := &TokenBucketLimiter{Rate: 1, Burst: 1, MaxKeys: 2, now: clock.now}
+	l.Allow("a")
+	clock.t = clock.t.Add(100 * time.Millisecond)
+	l.Allow("b")
+	clock.t = clock.t.Add(100 * time.Millisecond)
+	l.Allow("c")
+	if len(l.buckets) != 2 {
+		t.Fatalf("expected 2 keys, got %d", len(l.buckets))
+	}
+	if _, ok := l.buckets["a"]; ok {
+		t.Error("least recently seen key should have been evicted")
+	}
+
+	clock.t = clock.t.Add(time.Minute)
+	l.Allow("d")
+	if len(l.buckets) != 1 {
+		t.Errorf("idle keys should have been evicted, got %d keys", len(l.buckets))
+	}
+}
+
+func TestTools_RateLimit(t *testing.T) {
+	var testTools Tools
+	clock := &fakeClock{t: time.Unix(1700000000, 0)}
+	limiter := &TokenBucketLimiter{Rate: 0.5, Burst: 1, now: clock.now}
+	handler := testTools.RateLimit(limiter, HeaderKey("X-API-Key"), http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("X-API-Key", "secret")
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	if rr.Code != http.StatusNoContent || rr.Header().Get("RateLimit-Limit") != "1" || rr.Header().Get("RateLimit-Remaining") != "0" {
+		t.Fatalf("unexpected first response %d %v", rr.Code, rr.Header())
+	}
+
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	if rr.Code != http.StatusTooManyRequests || rr.Header().Get("Retry-After") != "2" {
+		t.Fatalf("unexpected second response %d %v", rr.Code, rr.Header())
+	}
+	var payload JSONResponse
+	if err := json.NewDecoder(rr.Body).Decode(&payload); err != nil {
+		t.Fatal(err)
+	}
+	if !payload.Error || payload.Message != "Too many requests" {
+		t.Errorf("unexpected payload %+v", payload)
+	}
+
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest("GET", "/", nil))
+	if rr.Code != http.StatusNoContent || rr.Header().Get("RateLimit-Limit") != "" {
+		t.Errorf("requests without a key must not be limited")
+	}
+}
+
+var clientIPTests = []struct {
+	name       string
+	remoteAddr string
+	forwarded  []string
+	expected   string
+}{
+	{name: "direct", remoteAddr: "203.0.113.7:1234", forwarded: []string{"198.51.100.1"}, expected: "203.0.113.7"},
+	{name: "through proxy", remoteAddr: "10.0.0.2:1234", forwarded: []string{"198.51.100.1"}, expected: "198.51.100.1"},
+	{name: "spoofed hop", remoteAddr: "10.0.0.2:1234", forwarded: []string{"1.2.3.4, 198.51.100.1, 10.0.0.3"}, expected: "198.51.100.1"},
+	{name: "several headers", remoteAddr: "10.0.0.2:1234", forwarded: []string{"1.2.3.4", "198.51.100.1"}, expected: "198.51.100.1"},
+	{name: "ipv6", remoteAddr: "[::1]:1234", forwarded: []string{"2001:db8::1"}, expected: "2001:db8::1"},
+}
+
+func TestTrustedProxies_ClientIP(t *testing.T) {
+	proxies, err := ParseTrustedProxies("10.0.0.0/8", "::1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range clientIPTests {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.RemoteAddr = e.remoteAddr
+		for _, value := range e.forwarded {
+			req.Header.Add("X-Forwarded-For", value)
+		}
+		if got := proxies.ClientIP(req); got != e.expected {
+			t.Errorf("%s: expected %s, got %s", e.name, e.expected, got)
+		}
+	}
+	if _, err = ParseTrustedProxies("not-an-ip"); err == nil {
+		t.Error("expected an error for an invalid proxy")
+	}
+}
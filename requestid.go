@@ -0,0 +1,150 @@
+package toolkit
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"log/slog"
+	"net/http"
+	"strings"
+)
+
+const defaultRequestIDHeader = "X-Request-ID"
+
+const maxRequestIDLength = 128
+
+type requestIDKey struct{}
+
+type traceContextKey struct{}
+
+// TraceContext is the W3C trace context of a request, from its traceparent and
+// tracestate headers.
+type TraceContext struct {
+	TraceID    string
+	ParentID   string
+	Flags      string
+	TraceState string
+}
+
+func ContextWithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// RequestIDFromContext returns the request ID stored by the RequestID
+// middleware, or "" if there is none.
+func RequestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+func ContextWithTraceContext(ctx context.Context, tc TraceContext) context.Context {
+	return context.WithValue(ctx, traceContextKey{}, tc)
+}
+
+func TraceContextFromContext(ctx context.Context) (TraceContext, bool) {
+	tc, ok := ctx.Value(traceContextKey{}).(TraceContext)
+	return tc, ok
+}
+
+// RequestID takes the request ID from the RequestIDHeader of the request, or
+// generates one with RequestIDScheme when it is missing or malformed, and the
+// trace context from its traceparent header, starting a new trace if there is
+// none. Both are stored in the request context, added to the attributes of
+// logged events, and forwarded by PushJSONToRemoteContext. The request ID is
+// also echoed in the response headers and in the body of ErrorJSON responses.
+func (t *Tools) RequestID(next http.Handler) http.Handler {
+	header := t.RequestIDHeader()
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(header)
+		if !validRequestID(id) {
+			var err error
+			if id, err = t.NewID(t.requestIDScheme); err != nil {
+				_ = t.ErrorJSON(w, err, http.StatusInternalServerError)
+				return
+			}
+		}
+		tc, ok := parseTraceparent(r.Header.Get("traceparent"))
+		if ok {
+			tc.TraceState = r.Header.Get("tracestate")
+		} else {
+			tc = TraceContext{TraceID: randomHex(16), Flags: "00"}
+		}
+
+		ctx := ContextWithRequestID(r.Context(), id)
+		ctx = ContextWithTraceContext(ctx, tc)
+		ctx = ContextWithLogAttrs(ctx, slog.String("request_id", id), slog.String("trace_id", tc.TraceID))
+		w.Header().Set(header, id)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// validRequestID accepts IDs made of characters that cannot be used to forge
+// log lines or headers.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for _, c := range id {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case strings.ContainsRune("-_.:+/=~", c):
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// parseTraceparent parses a version 00 traceparent header, as in
+// 00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01.
+func parseTraceparent(value string) (TraceContext, bool) {
+	parts := strings.Split(strings.TrimSpace(value), "-")
+	if len(parts) < 4 || parts[0] == "ff" || len(parts[0]) != 2 || !isLowerHex(parts[0]) {
+		return TraceContext{}, false
+	}
+	if parts[0] == "00" && len(parts) != 4 {
+		return TraceContext{}, false
+	}
+	tc := TraceContext{TraceID: parts[1], ParentID: parts[2], Flags: parts[3]}
+	if len(tc.TraceID) != 32 || !isLowerHex(tc.TraceID) || strings.Trim(tc.TraceID, "0") == "" {
+		return TraceContext{}, false
+	}
+	if len(tc.ParentID) != 16 || !isLowerHex(tc.ParentID) || strings.Trim(tc.ParentID, "0") == "" {
+		return TraceContext{}, false
+	}
+	if len(tc.Flags) != 2 || !isLowerHex(tc.Flags) {
+		return TraceContext{}, false
+	}
+	return tc, true
+}
+
+func isLowerHex(s string) bool {
+	for _, c := range s {
+		if !(c >= '0' && c <= '9' || c >= 'a' && c <= 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+// setCorrelationHeaders adds the request ID and a traceparent for a new child
+// span to an outbound request.
+func (t *Tools) setCorrelationHeaders(ctx context.Context, req *http.Request) {
+	if id := RequestIDFromContext(ctx); id != "" {
+		req.Header.Set(t.RequestIDHeader(), id)
+	}
+	if tc, ok := TraceContextFromContext(ctx); ok {
+		req.Header.Set("traceparent", "00-"+tc.TraceID+"-"+randomHex(8)+"-"+tc.Flags)
+		if tc.TraceState != "" {
+			req.Header.Set("tracestate", tc.TraceState)
+		}
+	}
+}
@@ -0,0 +1,116 @@
+package toolkit
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+var requestIDTests = []struct {
+	name        string
+	incoming    string
+	traceparent string
+	keepID      bool
+	keepTrace   bool
+}{
+	{name: "generated", keepID: false},
+	{name: "accepted", incoming: "abc-123", keepID: true},
+	{name: "malformed", incoming: "bad\nid", keepID: false},
+	{name: "too long", incoming: strings.Repeat("a", 129), keepID: false},
+	{name: "trace continued", traceparent: "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", keepTrace: true},
+	{name: "trace invalid", traceparent: "00-00000000000000000000000000000000-00f067aa0ba902b7-01", keepTrace: false},
+}
+
+func TestTools_RequestID(t *testing.T) {
+	for _, e := range requestIDTests {
+		testTools := Tools{requestIDScheme: IDUUIDv4}
+		var gotID string
+		var gotTrace TraceContext
+		handler := testTools.RequestID(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			gotID = RequestIDFromContext(r.Context())
+			gotTrace, _ = TraceContextFromContext(r.Context())
+		}))
+
+		req := httptest.NewRequest("GET", "/", nil)
+		if e.incoming != "" {
+			req.Header.Set("X-Request-ID", e.incoming)
+		}
+		if e.traceparent != "" {
+			req.Header.Set("traceparent", e.traceparent)
+		}
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+
+		if gotID == "" || rr.Header().Get("X-Request-ID") != gotID {
+			t.Errorf("%s: request ID %q not echoed, got %q", e.name, gotID, rr.Header().Get("X-Request-ID"))
+		}
+		if e.keepID && gotID != e.incoming {
+			t.Errorf("%s: expected %q, got %q", e.name, e.incoming, gotID)
+		}
+		if !e.keepID {
+			if _, err := ParseUUID(gotID); err != nil {
+				t.Errorf("%s: expected a generated UUID, got %q", e.name, gotID)
+			}
+		}
+		if len(gotTrace.TraceID) != 32 {
+			t.Errorf("%s: invalid trace ID %q", e.name, gotTrace.TraceID)
+		}
+		if e.keepTrace != (gotTrace.TraceID == "4bf92f3577b34da6a3ce929d0e0e4736") {
+			t.Errorf("%s: unexpected trace ID %q", e.name, gotTrace.TraceID)
+		}
+	}
+}
+
+func TestTools_RequestIDInErrorJSON(t *testing.T) {
+	var testTools Tools
+	handler := testTools.RequestID(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = testTools.ErrorJSON(w, errors.New("nope"))
+	}))
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("X-Request-ID", "req-1")
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	var payload JSONResponse
+	if err := json.NewDecoder(rr.Body).Decode(&payload); err != nil {
+		t.Fatal(err)
+	}
+	if payload.RequestID != "req-1" {
+		t.Errorf("expected request ID in error body, got %+v", payload)
+	}
+}
+
+func TestTools_PushJSONToRemoteForwardsCorrelation(t *testing.T) {
+	testTools := Tools{requestIDHeader: "X-Correlation-ID"}
+	var forwarded http.Header
+	client := NewTestClient(func(req *http.Request) *http.Response {
+		forwarded = req.Header.Clone()
+		return &http.Response{StatusCode: http.StatusOK, Body: http.NoBody, Header: make(http.Header)}
+	})
+
+	handler := testTools.RequestID(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _, _ = testTools.PushJSONToRemoteContext(r.Context(), "http://example.com/", struct{}{}, client)
+	}))
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("X-Correlation-ID", "req-7")
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	req.Header.Set("tracestate", "vendor=1")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	if forwarded.Get("X-Correlation-ID") != "req-7" {
+		t.Errorf("request ID not forwarded: %v", forwarded)
+	}
+	tc, ok := parseTraceparent(forwarded.Get("traceparent"))
+	if !ok || tc.TraceID != "4bf92f3577b34da6a3ce929d0e0e4736" || tc.Flags != "01" {
+		t.Errorf("unexpected traceparent %q", forwarded.Get("traceparent"))
+	}
+	if tc.ParentID == "00f067aa0ba902b7" {
+		t.Error("outbound call should use a new span ID")
+	}
+	if forwarded.Get("tracestate") != "vendor=1" {
+		t.Errorf("tracestate not forwarded: %q", forwarded.Get("tracestate"))
+	}
+}
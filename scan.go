@@ -0,0 +1,81 @@
+package toolkit
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// Scanner inspects the content of an uploaded file. An error means the scan
+// could not be performed, in which case the file stays in quarantine with a
+// pending status.
+type Scanner interface {
+	Scan(ctx context.Context, f *os.File) (ScanResult, error)
+}
+
+type ScanResult struct {
+	Infected  bool
+	Signature string
+}
+
+type ScanStatus string
+
+const (
+	ScanPending  ScanStatus = "pending"
+	ScanClean    ScanStatus = "clean"
+	ScanInfected ScanStatus = "infected"
+)
+
+type InfectedFileError struct {
+	FileName  string
+	Signature string
+}
+
+func (e *InfectedFileError) Error() string {
+	return fmt.Sprintf("File %s is infected (%s)", e.FileName, e.Signature)
+}
+
+func (t *Tools) quarantinePath(uploadDir string) string {
+	if t.quarantineDir != "" {
+		return t.quarantineDir
+	}
+	return filepath.Clean(uploadDir) + ".quarantine"
+}
+
+func (t *Tools) scanFile(ctx context.Context, path string, file *UploadedFile) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	result, err := t.scanner.Scan(ctx, f)
+	switch {
+	case err != nil:
+		file.Status = ScanPending
+	case result.Infected:
+		file.Status = ScanInfected
+		return &InfectedFileError{FileName: file.OriginalFileName, Signature: result.Signature}
+	default:
+		file.Status = ScanClean
+	}
+	return nil
+}
+
+// RescanQuarantined scans a file left pending in quarantine again and moves it
+// into uploadDir once it is found clean.
+func (t *Tools) RescanQuarantined(ctx context.Context, file *UploadedFile, uploadDir string) error {
+	quarantined := filepath.Join(t.quarantinePath(uploadDir), file.NewFileName)
+	if err := t.scanFile(ctx, quarantined, file); err != nil {
+		return err
+	}
+	if file.Status != ScanClean {
+		return fmt.Errorf("File %s could not be scanned", file.OriginalFileName)
+	}
+	name, err := moveUnique(quarantined, uploadDir, file.NewFileName)
+	if err != nil {
+		return err
+	}
+	file.NewFileName = name
+	return nil
+}
@@ -0,0 +1,112 @@
+package toolkit
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+type fakeScanner struct {
+	err error
+}
+
+func (s fakeScanner) Scan(ctx context.Context, f *os.File) (ScanResult, error) {
+	if s.err != nil {
+		return ScanResult{}, s.err
+	}
+	data, err := io.ReadAll(f)
+	if err != nil {
+		return ScanResult{}, err
+	}
+	if strings.Contains(string(data), "EICAR") {
+		return ScanResult{Infected: true, Signature: "Eicar-Test-Signature"}, nil
+	}
+	return ScanResult{}, nil
+}
+
+var scanTests = []struct {
+	name          string
+	content       string
+	scanErr       error
+	status        ScanStatus
+	errorExpected bool
+	inUploadDir   bool
+	inQuarantine  bool
+}{
+	{
+		name:        "clean",
+		content:     "hello world",
+		status:      ScanClean,
+		inUploadDir: true,
+	},
+	{
+		name:          "infected",
+		content:       "EICAR test file",
+		errorExpected: true,
+		inQuarantine:  true,
+	},
+	{
+		name:         "scanner unavailable",
+		content:      "hello world",
+		scanErr:      errors.New("connection refused"),
+		status:       ScanPending,
+		inQuarantine: true,
+	},
+}
+
+func TestTools_UploadFilesScan(t *testing.T) {
+	for _, e := range scanTests {
+		dir := t.TempDir()
+		uploadDir := filepath.Join(dir, "uploads")
+		var testTools Tools
+		testTools.scanner = fakeScanner{err: e.scanErr}
+		request := newMultipartRequest(t, map[string][]byte{"file.txt": []byte(e.content)}, "file.txt")
+
+		uploadedFiles, err := testTools.UploadFiles(request, uploadDir, false)
+		if err != nil && !e.errorExpected {
+			t.Errorf("%s - Error not expected, but got one : %s", e.name, err)
+		}
+		var infected *InfectedFileError
+		if e.errorExpected && !errors.As(err, &infected) {
+			t.Errorf("%s - Expected an InfectedFileError, got %v", e.name, err)
+		}
+		if !e.errorExpected && (len(uploadedFiles) != 1 || uploadedFiles[0].Status != e.status) {
+			t.Errorf("%s - Expected one file with status %s", e.name, e.status)
+		}
+		if _, err := os.Stat(filepath.Join(uploadDir, "file.txt")); (err == nil) != e.inUploadDir {
+			t.Errorf("%s - Presence in upload directory should be %t", e.name, e.inUploadDir)
+		}
+		if _, err := os.Stat(filepath.Join(uploadDir+".quarantine", "file.txt")); (err == nil) != e.inQuarantine {
+			t.Errorf("%s - Presence in quarantine should be %t", e.name, e.inQuarantine)
+		}
+	}
+}
+
+func TestTools_RescanQuarantined(t *testing.T) {
+	uploadDir := filepath.Join(t.TempDir(), "uploads")
+	var testTools Tools
+	testTools.scanner = fakeScanner{err: errors.New("connection refused")}
+	request := newMultipartRequest(t, map[string][]byte{"file.txt": []byte("hello world")}, "file.txt")
+	file, err := testTools.UploadOneFile(request, uploadDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if file.Status != ScanPending {
+		t.Fatalf("Expected pending status, got %s", file.Status)
+	}
+
+	testTools.scanner = fakeScanner{}
+	if err = testTools.RescanQuarantined(context.Background(), file, uploadDir); err != nil {
+		t.Fatal(err)
+	}
+	if file.Status != ScanClean {
+		t.Errorf("Expected clean status, got %s", file.Status)
+	}
+	if _, err := os.Stat(filepath.Join(uploadDir, file.NewFileName)); err != nil {
+		t.Error("File was not released from quarantine", err)
+	}
+}
@@ -0,0 +1,365 @@
+package toolkit
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const sessionIDLength = 43
+
+// Session is the state kept for a client between requests. Values are stored
+// JSON encoded.
+type Session struct {
+	ID        string                     `json:"id"`
+	Values    map[string]json.RawMessage `json:"values,omitempty"`
+	CreatedAt time.Time                  `json:"created_at"`
+	LastSeen  time.Time                  `json:"last_seen"`
+	// ExpiresAt is when the idle or the absolute timeout will end the
+	// session, zero if neither is set. It is updated on every save.
+	ExpiresAt time.Time `json:"expires_at,omitempty"`
+
+	mu         sync.Mutex
+	isNew      bool
+	modified   bool
+	destroyed  bool
+	previousID string
+}
+
+func (s *Session) Get(key string, dst interface{}) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	raw, ok := s.Values[key]
+	if !ok {
+		return false, nil
+	}
+	return true, json.Unmarshal(raw, dst)
+}
+
+func (s *Session) Set(key string, value interface{}) error {
+	raw, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.Values == nil {
+		s.Values = make(map[string]json.RawMessage)
+	}
+	s.Values[key] = raw
+	s.modified = true
+	return nil
+}
+
+func (s *Session) Delete(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.Values[key]; ok {
+		delete(s.Values, key)
+		s.modified = true
+	}
+}
+
+// RegenerateID gives the session a new ID, keeping its values. It should be
+// called whenever the privileges of the session change, such as on login, so
+// that an ID planted by an attacker becomes worthless.
+func (s *Session) RegenerateID() error {
+	id, err := TokenGenerator{Alphabet: AlphabetURLSafe}.Generate(sessionIDLength)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.previousID == "" && !s.isNew {
+		s.previousID = s.ID
+	}
+	s.ID = id
+	s.modified = true
+	return nil
+}
+
+// Destroy ends the session; its cookie is removed at the end of the request.
+func (s *Session) Destroy() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.destroyed = true
+}
+
+// SessionStore keeps sessions. Save returns the token to put in the session
+// cookie, and Load turns it back into the session, or nil when there is none.
+type SessionStore interface {
+	Load(ctx context.Context, token string) (*Session, error)
+	Save(ctx context.Context, s *Session) (string, error)
+	Delete(ctx context.Context, id string) error
+}
+
+// CookieSessionStore keeps the whole session in the cookie, which the
+// SessionManager signs or encrypts. Sessions cannot be revoked before they
+// expire and must stay under the 4KB cookie size limit.
+type CookieSessionStore struct{}
+
+func (CookieSessionStore) Load(ctx context.Context, token string) (*Session, error) {
+	var s Session
+	if err := json.Unmarshal([]byte(token), &s); err != nil {
+		return nil, nil
+	}
+	return &s, nil
+}
+
+func (CookieSessionStore) Save(ctx context.Context, s *Session) (string, error) {
+	data, err := json.Marshal(s)
+	return string(data), err
+}
+
+func (CookieSessionStore) Delete(ctx context.Context, id string) error {
+	return nil
+}
+
+// MemorySessionStore keeps sessions in memory, with the cookie holding only
+// the session ID. Expired sessions are dropped as new ones are saved.
+type MemorySessionStore struct {
+	mu        sync.Mutex
+	sessions  map[string][]byte
+	expires   map[string]time.Time
+	lastSweep time.Time
+}
+
+func (m *MemorySessionStore) Load(ctx context.Context, token string) (*Session, error) {
+	m.mu.Lock()
+	data, ok := m.sessions[token]
+	m.mu.Unlock()
+	if !ok {
+		return nil, nil
+	}
+	var s Session
+	if err := json.Unmarshal(data, &s); err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+func (m *MemorySessionStore) Save(ctx context.Context, s *Session) (string, error) {
+	data, err := json.Marshal(s)
+	if err != nil {
+		return "", err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.sessions == nil {
+		m.sessions = make(map[string][]byte)
+		m.expires = make(map[string]time.Time)
+	}
+	now := time.Now()
+	if now.Sub(m.lastSweep) > time.Minute {
+		for id, expires := range m.expires {
+			if !expires.IsZero() && now.After(expires) {
+				delete(m.sessions, id)
+				delete(m.expires, id)
+			}
+		}
+		m.lastSweep = now
+	}
+	m.sessions[s.ID] = data
+	m.expires[s.ID] = s.ExpiresAt
+	return s.ID, nil
+}
+
+func (m *MemorySessionStore) Delete(ctx context.Context, id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.sessions, id)
+	delete(m.expires, id)
+	return nil
+}
+
+// SessionManager loads the session of each request from its cookie and saves
+// it back before the response is written. A session ends after IdleTimeout
+// without requests or AbsoluteTimeout after it was created; a zero timeout is
+// not enforced. New sessions are only stored once something is set in them.
+type SessionManager struct {
+	Store           SessionStore
+	Cookie          *SecureCookie
+	CookieName      string
+	IdleTimeout     time.Duration
+	AbsoluteTimeout time.Duration
+
+	tools *Tools
+}
+
+// NewSessionManager returns a SessionManager using store and cookie, with a
+// "session" cookie, a 30 minute idle timeout and a 24 hour absolute timeout.
+func (t *Tools) NewSessionManager(store SessionStore, cookie *SecureCookie) *SessionManager {
+	return &SessionManager{
+		Store:           store,
+		Cookie:          cookie,
+		CookieName:      "session",
+		IdleTimeout:     30 * time.Minute,
+		AbsoluteTimeout: 24 * time.Hour,
+		tools:           t,
+	}
+}
+
+type sessionKey struct{}
+
+// SessionFromContext returns the session loaded by the SessionManager
+// middleware.
+func SessionFromContext(ctx context.Context) (*Session, bool) {
+	s, ok := ctx.Value(sessionKey{}).(*Session)
+	return s, ok
+}
+
+func (m *SessionManager) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s, err := m.load(r)
+		if err != nil {
+			m.tools.log(r.Context(), slog.LevelError, "session load failed", slog.String("error", err.Error()))
+			_ = m.tools.ErrorJSON(w, errors.New(http.StatusText(http.StatusInternalServerError)), http.StatusInternalServerError)
+			return
+		}
+		sw := &sessionWriter{ResponseWriter: w, commit: func() { m.commit(w, r, s) }}
+		next.ServeHTTP(sw, r.WithContext(context.WithValue(r.Context(), sessionKey{}, s)))
+		sw.flush()
+	})
+}
+
+func (m *SessionManager) load(r *http.Request) (*Session, error) {
+	now := time.Now()
+	var token string
+	if err := m.Cookie.Get(r, m.CookieName, &token); err == nil {
+		s, err := m.Store.Load(r.Context(), token)
+		if err != nil {
+			return nil, err
+		}
+		if s != nil && !m.expired(s, now) {
+			return s, nil
+		}
+		if s != nil {
+			_ = m.Store.Delete(r.Context(), s.ID)
+		}
+	}
+	s := &Session{CreatedAt: now.UTC(), LastSeen: now.UTC(), isNew: true}
+	if err := s.RegenerateID(); err != nil {
+		return nil, err
+	}
+	s.modified = false
+	return s, nil
+}
+
+func (m *SessionManager) expired(s *Session, now time.Time) bool {
+	return (m.IdleTimeout > 0 && now.Sub(s.LastSeen) > m.IdleTimeout) ||
+		(m.AbsoluteTimeout > 0 && now.Sub(s.CreatedAt) > m.AbsoluteTimeout)
+}
+
+// commit saves s and sets or removes its cookie. Existing sessions are saved
+// on every request so that their idle timeout starts over.
+func (m *SessionManager) commit(w http.ResponseWriter, r *http.Request, s *Session) {
+	s.mu.Lock()
+	destroyed, modified, isNew, previousID := s.destroyed, s.modified, s.isNew, s.previousID
+	s.mu.Unlock()
+	ctx := r.Context()
+
+	if previousID != "" {
+		_ = m.Store.Delete(ctx, previousID)
+	}
+	if destroyed {
+		_ = m.Store.Delete(ctx, s.ID)
+		if !isNew {
+			m.Cookie.Delete(w, m.CookieName)
+		}
+		return
+	}
+	if isNew && !modified {
+		return
+	}
+
+	now := time.Now().UTC()
+	s.mu.Lock()
+	s.LastSeen = now
+	s.ExpiresAt = time.Time{}
+	if m.IdleTimeout > 0 {
+		s.ExpiresAt = now.Add(m.IdleTimeout)
+	}
+	if m.AbsoluteTimeout > 0 {
+		if absolute := s.CreatedAt.Add(m.AbsoluteTimeout); s.ExpiresAt.IsZero() || absolute.Before(s.ExpiresAt) {
+			s.ExpiresAt = absolute
+		}
+	}
+	s.mu.Unlock()
+
+	token, err := m.Store.Save(ctx, s)
+	if err == nil {
+		var encoded string
+		if encoded, err = m.Cookie.Encode(m.CookieName, token); err == nil {
+			cookie := m.Cookie.cookie(m.CookieName, encoded)
+			if !s.ExpiresAt.IsZero() {
+				cookie.Expires = s.ExpiresAt
+				cookie.MaxAge = int(time.Until(s.ExpiresAt).Seconds())
+			}
+			http.SetCookie(w, cookie)
+		}
+	}
+	if err != nil {
+		m.tools.log(ctx, slog.LevelError, "session save failed", slog.String("error", err.Error()))
+	}
+}
+
+// GetCSRFToken and SetCSRFToken keep CSRF synchronizer tokens in the session,
+// so a SessionManager can be the Store of CSRFOptions when its middleware runs
+// first.
+func (m *SessionManager) GetCSRFToken(r *http.Request) (string, error) {
+	s, ok := SessionFromContext(r.Context())
+	if !ok {
+		return "", errors.New("No session in the request context")
+	}
+	var token string
+	_, err := s.Get("csrf_token", &token)
+	return token, err
+}
+
+func (m *SessionManager) SetCSRFToken(w http.ResponseWriter, r *http.Request, token string) error {
+	s, ok := SessionFromContext(r.Context())
+	if !ok {
+		return errors.New("No session in the request context")
+	}
+	return s.Set("csrf_token", token)
+}
+
+// sessionWriter commits the session just before the response is started,
+// while its cookie can still be set.
+type sessionWriter struct {
+	http.ResponseWriter
+	commit    func()
+	committed bool
+}
+
+func (w *sessionWriter) flush() {
+	if !w.committed {
+		w.committed = true
+		w.commit()
+	}
+}
+
+func (w *sessionWriter) WriteHeader(status int) {
+	w.flush()
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *sessionWriter) Write(b []byte) (int, error) {
+	w.flush()
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *sessionWriter) Flush() {
+	w.flush()
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (w *sessionWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
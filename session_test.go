@@ -0,0 +1,155 @@
+package toolkit
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// sessionClient replays the cookies set by a handler on the following
+// requests, like a browser would.
+type sessionClient struct {
+	handler http.Handler
+	cookie  *http.Cookie
+}
+
+func (c *sessionClient) do(path string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("GET", path, nil)
+	if c.cookie != nil {
+		req.AddCookie(c.cookie)
+	}
+	rr := httptest.NewRecorder()
+	c.handler.ServeHTTP(rr, req)
+	for _, cookie := range rr.Result().Cookies() {
+		if cookie.Name != "session" {
+			continue
+		}
+		c.cookie = cookie
+		if cookie.MaxAge < 0 {
+			c.cookie = nil
+		}
+	}
+	return rr
+}
+
+func newSessionHandler(t *testing.T, m *SessionManager) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/login", func(w http.ResponseWriter, r *http.Request) {
+		s, _ := SessionFromContext(r.Context())
+		if err := s.RegenerateID(); err != nil {
+			t.Fatal(err)
+		}
+		_ = s.Set("user", "alice")
+	})
+	mux.HandleFunc("/whoami", func(w http.ResponseWriter, r *http.Request) {
+		s, _ := SessionFromContext(r.Context())
+		var user string
+		_, _ = s.Get("user", &user)
+		_, _ = w.Write([]byte(user))
+	})
+	mux.HandleFunc("/logout", func(w http.ResponseWriter, r *http.Request) {
+		s, _ := SessionFromContext(r.Context())
+		s.Destroy()
+	})
+	return m.Middleware(mux)
+}
+
+func TestSessionManager(t *testing.T) {
+	var testTools Tools
+	for _, store := range []SessionStore{CookieSessionStore{}, &MemorySessionStore{}} {
+		cookie := &SecureCookie{Keys: map[string][]byte{"k1": []byte("secret")}, CurrentKey: "k1", Encrypt: true}
+		client := &sessionClient{handler: newSessionHandler(t, testTools.NewSessionManager(store, cookie))}
+
+		client.do("/whoami")
+		if client.cookie != nil {
+			t.Errorf("%T: an unmodified session should not set a cookie", store)
+		}
+		client.do("/login")
+		if client.cookie == nil || client.cookie.MaxAge <= 0 {
+			t.Fatalf("%T: expected a session cookie, got %+v", store, client.cookie)
+		}
+		if rr := client.do("/whoami"); rr.Body.String() != "alice" {
+			t.Errorf("%T: expected the session to hold the user, got %q", store, rr.Body.String())
+		}
+		client.do("/logout")
+		if client.cookie != nil {
+			t.Errorf("%T: expected the cookie to be removed", store)
+		}
+		if rr := client.do("/whoami"); rr.Body.String() != "" {
+			t.Errorf("%T: expected the session to be gone, got %q", store, rr.Body.String())
+		}
+	}
+}
+
+func TestSessionManager_RegenerateID(t *testing.T) {
+	var testTools Tools
+	store := &MemorySessionStore{}
+	cookie := &SecureCookie{Keys: map[string][]byte{"k1": []byte("secret")}, CurrentKey: "k1"}
+	client := &sessionClient{handler: newSessionHandler(t, testTools.NewSessionManager(store, cookie))}
+
+	client.do("/login")
+	planted := client.cookie
+	client.do("/login")
+	if client.cookie.Value == planted.Value {
+		t.Fatal("expected a new session cookie")
+	}
+	if len(store.sessions) != 1 {
+		t.Errorf("expected the previous session to be deleted, %d left", len(store.sessions))
+	}
+	client.cookie = planted
+	if rr := client.do("/whoami"); rr.Body.String() != "" {
+		t.Error("the previous session ID still works")
+	}
+}
+
+func TestSessionManager_Timeouts(t *testing.T) {
+	var testTools Tools
+	var sessionTimeoutTests = []struct {
+		name      string
+		createdAt time.Duration
+		lastSeen  time.Duration
+		valid     bool
+	}{
+		{name: "active", createdAt: -time.Hour, lastSeen: -time.Minute, valid: true},
+		{name: "idle", createdAt: -time.Hour, lastSeen: -time.Hour},
+		{name: "absolute", createdAt: -25 * time.Hour, lastSeen: -time.Minute},
+	}
+	for _, e := range sessionTimeoutTests {
+		store := &MemorySessionStore{}
+		cookie := &SecureCookie{Keys: map[string][]byte{"k1": []byte("secret")}, CurrentKey: "k1"}
+		m := testTools.NewSessionManager(store, cookie)
+		now := time.Now()
+		s := &Session{ID: "abc", CreatedAt: now.Add(e.createdAt), LastSeen: now.Add(e.lastSeen)}
+		_ = s.Set("user", "alice")
+		token, _ := store.Save(context.Background(), s)
+		encoded, _ := cookie.Encode("session", token)
+
+		client := &sessionClient{handler: newSessionHandler(t, m), cookie: &http.Cookie{Name: "session", Value: encoded}}
+		rr := client.do("/whoami")
+		if got := rr.Body.String() == "alice"; got != e.valid {
+			t.Errorf("%s: expected valid %v, got %q", e.name, e.valid, rr.Body.String())
+		}
+		if _, ok := store.sessions["abc"]; ok != e.valid {
+			t.Errorf("%s: expected stored %v, got %v", e.name, e.valid, ok)
+		}
+	}
+}
+
+func TestSessionManager_CSRFStore(t *testing.T) {
+	var testTools Tools
+	cookie := &SecureCookie{Keys: map[string][]byte{"k1": []byte("secret")}, CurrentKey: "k1"}
+	m := testTools.NewSessionManager(&MemorySessionStore{}, cookie)
+	var pageToken string
+	client := &sessionClient{handler: m.Middleware(testTools.CSRF(CSRFOptions{Store: m}, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		pageToken = CSRFToken(r)
+	})))}
+
+	client.do("/form")
+	first := pageToken
+	client.do("/form")
+	if first == "" || pageToken != first || client.cookie == nil {
+		t.Errorf("expected the token to be kept in the session, got %q then %q", first, pageToken)
+	}
+}
@@ -0,0 +1,129 @@
+package toolkit
+
+import (
+	"errors"
+	"mime"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestURLSigner_Verify(t *testing.T) {
+	signer := &URLSigner{
+		Keys:       map[string][]byte{"2023": []byte("old secret"), "2024": []byte("new secret")},
+		CurrentKey: "2023",
+	}
+	oldLink, err := signer.Sign("/download/report.pdf?name=report.pdf", time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer.CurrentKey = "2024"
+	link, err := signer.Sign("/download/report.pdf?name=report.pdf", time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expiredLink, _ := signer.Sign("/download/report.pdf", time.Now().Add(-time.Minute))
+
+	var signedURLTests = []struct {
+		name     string
+		link     string
+		tamper   func(u *url.URL)
+		expected error
+	}{
+		{name: "valid", link: link},
+		{name: "signed with a rotated key", link: oldLink},
+		{name: "expired", link: expiredLink, expected: ErrSignedURLExpired},
+		{name: "other path", link: link, tamper: func(u *url.URL) { u.Path = "/download/secret.pdf" }, expected: ErrSignedURLInvalid},
+		{name: "changed parameter", link: link, tamper: func(u *url.URL) {
+			q := u.Query()
+			q.Set("name", "other.pdf")
+			u.RawQuery = q.Encode()
+		}, expected: ErrSignedURLInvalid},
+		{name: "added parameter", link: link, tamper: func(u *url.URL) { u.RawQuery += "&foo=bar" }, expected: ErrSignedURLInvalid},
+		{name: "extended expiry", link: expiredLink, tamper: func(u *url.URL) {
+			q := u.Query()
+			q.Set("expires", "99999999999")
+			u.RawQuery = q.Encode()
+		}, expected: ErrSignedURLInvalid},
+		{name: "unknown key", link: link, tamper: func(u *url.URL) {
+			q := u.Query()
+			q.Set("kid", "2022")
+			u.RawQuery = q.Encode()
+		}, expected: ErrSignedURLInvalid},
+	}
+
+	for _, e := range signedURLTests {
+		u, err := url.Parse(e.link)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if e.tamper != nil {
+			e.tamper(u)
+		}
+		if err = signer.Verify(u); !errors.Is(err, e.expected) {
+			t.Errorf("%s - Expected %v, got %v", e.name, e.expected, err)
+		}
+	}
+
+	delete(signer.Keys, "2023")
+	u, _ := url.Parse(oldLink)
+	if err = signer.Verify(u); !errors.Is(err, ErrSignedURLInvalid) {
+		t.Errorf("Link signed with a removed key should be invalid, got %v", err)
+	}
+}
+
+func TestURLSigner_SelectedParams(t *testing.T) {
+	signer := &URLSigner{Keys: map[string][]byte{"k": []byte("secret")}, CurrentKey: "k", Params: []string{"name"}}
+	link, _ := signer.Sign("/download/a.pdf?name=a.pdf", time.Now().Add(time.Hour))
+	u, _ := url.Parse(link + "&utm_source=mail")
+	if err := signer.Verify(u); err != nil {
+		t.Errorf("Unsigned parameters should be ignored, got %v", err)
+	}
+}
+
+func TestTools_SignedDownloadHandler(t *testing.T) {
+	var testTools Tools
+	signer := &URLSigner{Keys: map[string][]byte{"k": []byte("secret")}, CurrentKey: "k"}
+	handler := testTools.SignedDownloadHandler(signer, "/download/", "./testdata")
+
+	link, _ := signer.Sign("/download/FileToDownload.go?name=pop.go", time.Now().Add(time.Hour))
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest("GET", link, nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Wrong status code, expected 200 but got %d", rr.Code)
+	}
+	if rr.Header().Get("Content-Disposition") != "attachment; filename=pop.go" {
+		t.Error("Wrong content disposition, actual disposition : ", rr.Header().Get("Content-Disposition"))
+	}
+
+	link, _ = signer.Sign("/download/FileToDownload.go?name="+url.QueryEscape(`a"; b=c.go`), time.Now().Add(time.Hour))
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest("GET", link, nil))
+	if _, params, err := mime.ParseMediaType(rr.Header().Get("Content-Disposition")); err != nil || params["filename"] != `a"; b=c.go` || len(params) != 1 {
+		t.Error("Name not escaped, actual disposition : ", rr.Header().Get("Content-Disposition"))
+	}
+
+	// A name left out of the signed parameters cannot be changed by the client.
+	partial := &URLSigner{Keys: signer.Keys, CurrentKey: "k", Params: []string{"user"}}
+	link, _ = partial.Sign("/download/FileToDownload.go?user=1", time.Now().Add(time.Hour))
+	rr = httptest.NewRecorder()
+	testTools.SignedDownloadHandler(partial, "/download/", "./testdata").ServeHTTP(rr, httptest.NewRequest("GET", link+"&name=evil.exe", nil))
+	if rr.Code != http.StatusOK || rr.Header().Get("Content-Disposition") != "attachment; filename=FileToDownload.go" {
+		t.Error("Unsigned name was used, actual disposition : ", rr.Header().Get("Content-Disposition"))
+	}
+
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest("GET", "/download/FileToDownload.go", nil))
+	if rr.Code != http.StatusForbidden {
+		t.Errorf("Wrong status code for an unsigned link, expected 403 but got %d", rr.Code)
+	}
+
+	link, _ = signer.Sign("/download/FileToDownload.go", time.Now().Add(-time.Hour))
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest("GET", link, nil))
+	if rr.Code != http.StatusGone {
+		t.Errorf("Wrong status code for an expired link, expected 410 but got %d", rr.Code)
+	}
+}
@@ -0,0 +1,287 @@
+package toolkit
+
+import (
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// SlugOptions changes how Slugify builds a slug. Language selects extra
+// transliteration rules for German ("de"), Danish and Norwegian ("da", "no",
+// "nb", "nn"), Ukrainian ("uk") and Bulgarian ("bg"); regional variants such
+// as "de-AT" use the rules of their language. KeepUnicode keeps letters and
+// digits of every script instead of transliterating them to ASCII.
+//
+// Replacements are applied to the lowercased string before anything else, as
+// separate words, so {"&": "and"} turns "Fish&Chips" into "fish-and-chips".
+// StopWords are dropped unless nothing else is left. MaxLength, counted in
+// characters, cuts the slug on a word boundary, and Separator defaults to "-".
+// RandomSuffix makes UniqueSlug use random suffixes straight away instead of
+// trying -2, -3 and so on first.
+type SlugOptions struct {
+	Language     string
+	KeepUnicode  bool
+	MaxLength    int
+	Separator    string
+	StopWords    []string
+	Replacements map[string]string
+	RandomSuffix bool
+}
+
+func (t *Tools) Slugify(s string, opts ...SlugOptions) (string, error) {
+	if s == "" {
+		return "", errors.New("String is empty")
+	}
+	var o SlugOptions
+	if len(opts) > 0 {
+		o = opts[0]
+	}
+
+	lowered := replaceSlugWords(strings.ToLower(s), o.Replacements)
+	isSeparator := func(r rune) bool {
+		return !(r >= 'a' && r <= 'z' || r >= '0' && r <= '9')
+	}
+	if o.KeepUnicode {
+		isSeparator = func(r rune) bool {
+			return !unicode.IsLetter(r) && !unicode.IsMark(r) && !unicode.IsNumber(r)
+		}
+	} else {
+		lowered = Transliterate(lowered, o.Language)
+	}
+	words := removeStopWords(strings.FieldsFunc(lowered, isSeparator), o.StopWords)
+	if len(words) == 0 {
+		return "", errors.New("Slug is empty")
+	}
+	return joinSlugWords(words, o.separator(), o.MaxLength), nil
+}
+
+// UniqueSlug returns the slug of s, made unique by appending -2, -3, ... or a
+// short random suffix until exists reports that it is free. The suffix always
+// fits within MaxLength; when MaxLength is too small to hold the separator and
+// a suffix, UniqueSlug fails once the slug itself is taken.
+func (t *Tools) UniqueSlug(s string, exists func(slug string) (bool, error), opts ...SlugOptions) (string, error) {
+	var o SlugOptions
+	if len(opts) > 0 {
+		o = opts[0]
+	}
+	slug, err := t.Slugify(s, o)
+	if err != nil {
+		return "", err
+	}
+
+	const numericAttempts, randomAttempts = 100, 10
+	for i := 1; i <= numericAttempts+randomAttempts; i++ {
+		candidate := slug
+		switch {
+		case i == 1:
+		case i <= numericAttempts && !o.RandomSuffix:
+			if candidate, err = addSlugSuffix(slug, strconv.Itoa(i), o); err != nil {
+				return "", err
+			}
+		default:
+			suffix, err := t.GenerateToken(6, slugSuffixAlphabet)
+			if err != nil {
+				return "", err
+			}
+			if candidate, err = addSlugSuffix(slug, suffix, o); err != nil {
+				return "", err
+			}
+		}
+		taken, err := exists(candidate)
+		if err != nil {
+			return "", err
+		}
+		if !taken {
+			return candidate, nil
+		}
+	}
+	return "", errors.New("Could not find a unique slug")
+}
+
+const slugSuffixAlphabet = "0123456789abcdefghijklmnopqrstuvwxyz"
+
+func (o SlugOptions) separator() string {
+	if o.Separator == "" {
+		return "-"
+	}
+	return o.Separator
+}
+
+func addSlugSuffix(slug, suffix string, o SlugOptions) (string, error) {
+	sep := o.separator()
+	if o.MaxLength > 0 {
+		keep := o.MaxLength - utf8.RuneCountInString(sep+suffix)
+		if keep < 0 {
+			return "", errors.New("MaxLength is too small to add a suffix to the slug")
+		}
+		if r := []rune(slug); len(r) > keep {
+			slug = strings.TrimRight(string(r[:keep]), sep)
+		}
+	}
+	if slug == "" {
+		return suffix, nil
+	}
+	return slug + sep + suffix, nil
+}
+
+func replaceSlugWords(s string, replacements map[string]string) string {
+	if len(replacements) == 0 {
+		return s
+	}
+	keys := make([]string, 0, len(replacements))
+	for key := range replacements {
+		if key != "" {
+			keys = append(keys, key)
+		}
+	}
+	// Longer keys first, so that "&&" wins over "&".
+	sort.Slice(keys, func(i, j int) bool {
+		if len(keys[i]) != len(keys[j]) {
+			return len(keys[i]) > len(keys[j])
+		}
+		return keys[i] < keys[j]
+	})
+	oldnew := make([]string, 0, 2*len(keys))
+	for _, key := range keys {
+		oldnew = append(oldnew, strings.ToLower(key), " "+strings.ToLower(replacements[key])+" ")
+	}
+	return strings.NewReplacer(oldnew...).Replace(s)
+}
+
+func removeStopWords(words, stopWords []string) []string {
+	if len(stopWords) == 0 {
+		return words
+	}
+	stop := make(map[string]bool, len(stopWords))
+	for _, w := range stopWords {
+		stop[strings.ToLower(w)] = true
+	}
+	kept := make([]string, 0, len(words))
+	for _, w := range words {
+		if !stop[w] {
+			kept = append(kept, w)
+		}
+	}
+	if len(kept) == 0 {
+		return words
+	}
+	return kept
+}
+
+func joinSlugWords(words []string, sep string, maxLength int) string {
+	slug := strings.Join(words, sep)
+	if maxLength <= 0 || utf8.RuneCountInString(slug) <= maxLength {
+		return slug
+	}
+	slug = ""
+	length, sepLength := 0, utf8.RuneCountInString(sep)
+	for i, w := range words {
+		wordLength := utf8.RuneCountInString(w)
+		if i == 0 {
+			if wordLength > maxLength {
+				return string([]rune(w)[:maxLength])
+			}
+			slug, length = w, wordLength
+			continue
+		}
+		if length+sepLength+wordLength > maxLength {
+			break
+		}
+		slug += sep + w
+		length += sepLength + wordLength
+	}
+	return slug
+}
+
+// Transliterate replaces Latin letters with diacritics, Cyrillic and Greek
+// letters with their ASCII equivalents, using the rules of language when it
+// has specific ones. Case is kept, so "Жук" becomes "Zhuk" and "ЖУК" becomes
+// "ZHUK". Other characters are left untouched.
+func Transliterate(s, language string) string {
+	language, _, _ = strings.Cut(strings.ToLower(language), "-")
+	language, _, _ = strings.Cut(language, "_")
+	rules := transliterationRules[language]
+
+	runes := []rune(s)
+	at := func(i int) rune {
+		if i < 0 || i >= len(runes) {
+			return 0
+		}
+		return runes[i]
+	}
+	var b strings.Builder
+	b.Grow(len(s))
+	for i := 0; i < len(runes); i++ {
+		r, start := runes[i], i
+		lower := unicode.ToLower(r)
+		replacement, ok := greekDigraphs[[2]rune{lower, unicode.ToLower(at(i + 1))}]
+		if ok {
+			i++
+		} else if replacement, ok = rules[lower]; !ok {
+			replacement, ok = transliterations[lower]
+		}
+		switch {
+		case !ok && unicode.Is(unicode.Mn, r):
+		case !ok:
+			b.WriteRune(r)
+		case lower == r || replacement == "":
+			b.WriteString(replacement)
+		case unicode.IsUpper(at(i+1)) || !unicode.IsLetter(at(i+1)) && unicode.IsUpper(at(start-1)):
+			// Part of an uppercase word.
+			b.WriteString(strings.ToUpper(replacement))
+		default:
+			b.WriteString(strings.ToUpper(replacement[:1]) + replacement[1:])
+		}
+	}
+	return b.String()
+}
+
+var transliterationRules = map[string]map[rune]string{
+	"de": {'ä': "ae", 'ö': "oe", 'ü': "ue", 'ß': "ss"},
+	"da": {'æ': "ae", 'ø': "oe", 'å': "aa"},
+	"no": {'æ': "ae", 'ø': "oe", 'å': "aa"},
+	"nb": {'æ': "ae", 'ø': "oe", 'å': "aa"},
+	"nn": {'æ': "ae", 'ø': "oe", 'å': "aa"},
+	"uk": {'г': "h", 'ґ': "g", 'и': "y", 'і': "i", 'ї': "yi", 'є': "ye", 'й': "i", 'щ': "shch"},
+	"bg": {'щ': "sht", 'ъ': "a", 'ь': "y", 'ю': "yu", 'я': "ya"},
+}
+
+var greekDigraphs = map[[2]rune]string{
+	{'ο', 'υ'}: "ou", {'ο', 'ύ'}: "ou",
+	{'α', 'υ'}: "av", {'α', 'ύ'}: "av",
+	{'ε', 'υ'}: "ev", {'ε', 'ύ'}: "ev",
+}
+
+var transliterations = map[rune]string{
+	// Latin
+	'à': "a", 'á': "a", 'â': "a", 'ã': "a", 'ä': "a", 'å': "a", 'ā': "a", 'ă': "a", 'ą': "a",
+	'æ': "ae", 'ç': "c", 'ć': "c", 'ĉ': "c", 'ċ': "c", 'č': "c", 'ď': "d", 'đ': "d", 'ð': "d",
+	'è': "e", 'é': "e", 'ê': "e", 'ë': "e", 'ē': "e", 'ĕ': "e", 'ė': "e", 'ę': "e", 'ě': "e",
+	'ĝ': "g", 'ğ': "g", 'ġ': "g", 'ģ': "g", 'ĥ': "h", 'ħ': "h",
+	'ì': "i", 'í': "i", 'î': "i", 'ï': "i", 'ĩ': "i", 'ī': "i", 'ĭ': "i", 'į': "i", 'ı': "i", 'ĳ': "ij",
+	'ĵ': "j", 'ķ': "k", 'ĺ': "l", 'ļ': "l", 'ľ': "l", 'ŀ': "l", 'ł': "l",
+	'ñ': "n", 'ń': "n", 'ņ': "n", 'ň': "n", 'ŉ': "n",
+	'ò': "o", 'ó': "o", 'ô': "o", 'õ': "o", 'ö': "o", 'ø': "o", 'ō': "o", 'ŏ': "o", 'ő': "o", 'œ': "oe",
+	'ŕ': "r", 'ŗ': "r", 'ř': "r", 'ś': "s", 'ŝ': "s", 'ş': "s", 'š': "s", 'ș': "s", 'ß': "ss",
+	'ţ': "t", 'ť': "t", 'ŧ': "t", 'ț': "t", 'þ': "th",
+	'ù': "u", 'ú': "u", 'û': "u", 'ü': "u", 'ũ': "u", 'ū': "u", 'ŭ': "u", 'ů': "u", 'ű': "u", 'ų': "u",
+	'ŵ': "w", 'ý': "y", 'ÿ': "y", 'ŷ': "y", 'ź': "z", 'ż': "z", 'ž': "z",
+
+	// Cyrillic
+	'а': "a", 'б': "b", 'в': "v", 'г': "g", 'д': "d", 'е': "e", 'ё': "yo", 'ж': "zh", 'з': "z",
+	'и': "i", 'й': "y", 'к': "k", 'л': "l", 'м': "m", 'н': "n", 'о': "o", 'п': "p", 'р': "r",
+	'с': "s", 'т': "t", 'у': "u", 'ф': "f", 'х': "kh", 'ц': "ts", 'ч': "ch", 'ш': "sh", 'щ': "shch",
+	'ъ': "", 'ы': "y", 'ь': "", 'э': "e", 'ю': "yu", 'я': "ya",
+	'є': "ye", 'і': "i", 'ї': "yi", 'ґ': "g", 'ў': "u",
+	'ђ': "dj", 'ј': "j", 'љ': "lj", 'њ': "nj", 'ћ': "c", 'џ': "dz", 'ѓ': "gj", 'ќ': "kj", 'ѕ': "dz",
+
+	// Greek
+	'α': "a", 'β': "v", 'γ': "g", 'δ': "d", 'ε': "e", 'ζ': "z", 'η': "i", 'θ': "th", 'ι': "i",
+	'κ': "k", 'λ': "l", 'μ': "m", 'ν': "n", 'ξ': "x", 'ο': "o", 'π': "p", 'ρ': "r", 'σ': "s",
+	'ς': "s", 'τ': "t", 'υ': "y", 'φ': "f", 'χ': "ch", 'ψ': "ps", 'ω': "o",
+	'ά': "a", 'έ': "e", 'ή': "i", 'ί': "i", 'ϊ': "i", 'ΐ': "i", 'ό': "o", 'ύ': "y", 'ϋ': "y",
+	'ΰ': "y", 'ώ': "o",
+}
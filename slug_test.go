@@ -0,0 +1,164 @@
+package toolkit
+
+import "testing"
+
+var transliterationTests = []struct {
+	name          string
+	s             string
+	options       SlugOptions
+	expected      string
+	errorExpected bool
+}{
+	{name: "french", s: "Crème Brûlée", expected: "creme-brulee"},
+	{name: "polish", s: "Zażółć gęślą jaźń", expected: "zazolc-gesla-jazn"},
+	{name: "german default", s: "Grüße aus Köln", expected: "grusse-aus-koln"},
+	{name: "german rules", s: "Grüße aus Köln", options: SlugOptions{Language: "de"}, expected: "gruesse-aus-koeln"},
+	{name: "german regional", s: "Über", options: SlugOptions{Language: "de-AT"}, expected: "ueber"},
+	{name: "danish rules", s: "Ærø Ålborg", options: SlugOptions{Language: "da"}, expected: "aeroe-aalborg"},
+	{name: "russian", s: "Привет, мир!", expected: "privet-mir"},
+	{name: "ukrainian", s: "Київ", options: SlugOptions{Language: "uk"}, expected: "kyyiv"},
+	{name: "bulgarian", s: "Щастие", options: SlugOptions{Language: "bg"}, expected: "shtastie"},
+	{name: "greek", s: "Καλημέρα κόσμε", expected: "kalimera-kosme"},
+	{name: "greek digraph", s: "Ουρανός", expected: "ouranos"},
+	{name: "turkish dotted capital", s: "İstanbul", expected: "istanbul"},
+	{name: "keep unicode", s: "Crème Brûlée", options: SlugOptions{KeepUnicode: true}, expected: "crème-brûlée"},
+	{name: "keep unicode japanese", s: "ハロー ワールド!", options: SlugOptions{KeepUnicode: true}, expected: "ハロー-ワールド"},
+	{name: "japanese still empty", s: "ハローワールド", errorExpected: true},
+}
+
+func TestTools_SlugifyTransliteration(t *testing.T) {
+	var testTools Tools
+	for _, e := range transliterationTests {
+		slug, err := testTools.Slugify(e.s, e.options)
+		if err != nil && !e.errorExpected {
+			t.Errorf("%s - Error not expected, but got one : %s", e.name, err)
+		}
+		if err == nil && e.errorExpected {
+			t.Errorf("%s - Error expected, but got none.", e.name)
+		}
+		if slug != e.expected {
+			t.Errorf("%s - Slug is not '%s': %s", e.name, e.expected, slug)
+		}
+	}
+}
+
+var transliterateCaseTests = []struct {
+	s        string
+	language string
+	expected string
+}{
+	{s: "Ärger Ελλάδα Москва", language: "de", expected: "Aerger Ellada Moskva"},
+	{s: "Жук ЖУК", expected: "Zhuk ZHUK"},
+	{s: "ЩИ", language: "uk", expected: "SHCHY"},
+	{s: "Ουρανός ΟΥΡΑΝΟΣ", expected: "Ouranos OURANOS"},
+	{s: "Łódź", expected: "Lodz"},
+}
+
+func TestTransliterate(t *testing.T) {
+	for _, e := range transliterateCaseTests {
+		if got := Transliterate(e.s, e.language); got != e.expected {
+			t.Errorf("%s - Expected '%s', got '%s'", e.s, e.expected, got)
+		}
+	}
+}
+
+var slugOptionTests = []struct {
+	name     string
+	s        string
+	options  SlugOptions
+	expected string
+}{
+	{
+		name:     "max length on word boundary",
+		s:        "The quick brown fox jumps over the lazy dog",
+		options:  SlugOptions{MaxLength: 20},
+		expected: "the-quick-brown-fox",
+	},
+	{
+		name:     "max length cuts a single long word",
+		s:        "Supercalifragilisticexpialidocious",
+		options:  SlugOptions{MaxLength: 10},
+		expected: "supercalif",
+	},
+	{
+		name:     "separator",
+		s:        "Now is the time",
+		options:  SlugOptions{Separator: "_"},
+		expected: "now_is_the_time",
+	},
+	{
+		name:     "stop words",
+		s:        "The Lord of the Rings",
+		options:  SlugOptions{StopWords: []string{"the", "of", "a"}},
+		expected: "lord-rings",
+	},
+	{
+		name:     "only stop words",
+		s:        "The The",
+		options:  SlugOptions{StopWords: []string{"the"}},
+		expected: "the-the",
+	},
+	{
+		name:     "replacements",
+		s:        "Fish&Chips @ home",
+		options:  SlugOptions{Replacements: map[string]string{"&": "and", "@": "at"}},
+		expected: "fish-and-chips-at-home",
+	},
+	{
+		name:     "longest replacement first",
+		s:        "C++ && Go",
+		options:  SlugOptions{Replacements: map[string]string{"&&": "and", "&": "amp", "++": "pp"}},
+		expected: "c-pp-and-go",
+	},
+}
+
+func TestTools_SlugifyOptions(t *testing.T) {
+	var testTools Tools
+	for _, e := range slugOptionTests {
+		slug, err := testTools.Slugify(e.s, e.options)
+		if err != nil {
+			t.Errorf("%s - Error not expected, but got one : %s", e.name, err)
+		}
+		if slug != e.expected {
+			t.Errorf("%s - Slug is not '%s': %s", e.name, e.expected, slug)
+		}
+	}
+}
+
+func TestTools_UniqueSlug(t *testing.T) {
+	var testTools Tools
+	taken := map[string]bool{"hello-world": true, "hello-world-2": true}
+	exists := func(slug string) (bool, error) { return taken[slug], nil }
+
+	slug, err := testTools.UniqueSlug("Hello World", exists)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if slug != "hello-world-3" {
+		t.Errorf("Expected hello-world-3, got %s", slug)
+	}
+
+	slug, _ = testTools.UniqueSlug("Hello World", exists, SlugOptions{MaxLength: 12})
+	if slug != "hello-worl-2" {
+		t.Errorf("Suffix should fit within the max length, got %s", slug)
+	}
+
+	slug, _ = testTools.UniqueSlug("Hello World", exists, SlugOptions{RandomSuffix: true})
+	if len(slug) != len("hello-world-")+6 || slug[:12] != "hello-world-" {
+		t.Errorf("Expected a random suffix, got %s", slug)
+	}
+
+	taken["hel"] = true
+	slug, err = testTools.UniqueSlug("Hello World", exists, SlugOptions{MaxLength: 3, RandomSuffix: true})
+	if err == nil || err.Error() == "Could not find a unique slug" {
+		t.Errorf("Error expected when the suffix does not fit within the max length, got %q, %v", slug, err)
+	}
+	if slug, _ = testTools.UniqueSlug("Hi", exists, SlugOptions{MaxLength: 3}); slug != "hi" {
+		t.Errorf("Expected a free slug to be kept with a small max length, got %s", slug)
+	}
+
+	_, err = testTools.UniqueSlug("Hello World", func(string) (bool, error) { return true, nil })
+	if err == nil {
+		t.Error("Error expected when every slug is taken, but got none.")
+	}
+}
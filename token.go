@@ -0,0 +1,111 @@
+package toolkit
+
+import (
+	"crypto/rand"
+	"errors"
+	"io"
+	"math"
+	"math/bits"
+)
+
+const (
+	AlphabetDefault      = randomStringSource
+	AlphabetHex          = "0123456789abcdef"
+	AlphabetCrockford    = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+	AlphabetURLSafe      = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789-_"
+	AlphabetDigits       = "0123456789"
+	AlphabetAlphanumeric = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+	AlphabetNoLookalikes = "23456789ABCDEFGHJKLMNPQRSTUVWXYZabcdefghijkmnpqrstuvwxyz"
+)
+
+// TokenGenerator draws random tokens from Alphabet. Every character is picked
+// with the same probability: random bytes are masked to the smallest power of
+// two covering the alphabet and values outside of it are rejected. The zero
+// value uses AlphabetDefault and crypto/rand.
+type TokenGenerator struct {
+	Alphabet string
+	Reader   io.Reader
+}
+
+func (g TokenGenerator) Generate(n int) (string, error) {
+	alphabet, err := g.alphabet()
+	if err != nil {
+		return "", err
+	}
+	if n < 0 {
+		return "", errors.New("Token length must not be negative")
+	}
+	reader := g.Reader
+	if reader == nil {
+		reader = rand.Reader
+	}
+
+	size := len(alphabet)
+	mask := byte(1<<bits.Len(uint(size-1)) - 1)
+	// Ask for a little more than n bytes per round, since some of them are
+	// rejected when the alphabet size is not a power of two.
+	step := n + n/2 + 1
+	buff := make([]byte, step)
+	token := make([]byte, 0, n)
+	for len(token) < n {
+		if _, err = io.ReadFull(reader, buff); err != nil {
+			return "", err
+		}
+		for _, b := range buff {
+			if idx := int(b & mask); idx < size {
+				token = append(token, alphabet[idx])
+				if len(token) == n {
+					break
+				}
+			}
+		}
+	}
+	return string(token), nil
+}
+
+// EntropyBits returns the entropy, in bits, of a token of length n.
+func (g TokenGenerator) EntropyBits(n int) float64 {
+	alphabet, err := g.alphabet()
+	if err != nil {
+		return 0
+	}
+	return float64(n) * math.Log2(float64(len(alphabet)))
+}
+
+// LengthFor returns the shortest token length giving at least the requested
+// number of bits of entropy.
+func (g TokenGenerator) LengthFor(entropyBits float64) int {
+	alphabet, err := g.alphabet()
+	if err != nil {
+		return 0
+	}
+	return int(math.Ceil(entropyBits / math.Log2(float64(len(alphabet)))))
+}
+
+func (g TokenGenerator) alphabet() (string, error) {
+	if g.Alphabet == "" {
+		return AlphabetDefault, nil
+	}
+	if len(g.Alphabet) < 2 {
+		return "", errors.New("Alphabet must contain at least 2 characters")
+	}
+	var seen [128]bool
+	for i := 0; i < len(g.Alphabet); i++ {
+		if g.Alphabet[i] >= 128 {
+			return "", errors.New("Alphabet must only contain ASCII characters")
+		}
+		if seen[g.Alphabet[i]] {
+			return "", errors.New("Alphabet contains duplicate characters")
+		}
+		seen[g.Alphabet[i]] = true
+	}
+	return g.Alphabet, nil
+}
+
+func (t *Tools) GenerateToken(n int, alphabet ...string) (string, error) {
+	var g TokenGenerator
+	if len(alphabet) > 0 {
+		g.Alphabet = alphabet[0]
+	}
+	return g.Generate(n)
+}
@@ -0,0 +1,94 @@
+package toolkit
+
+import (
+	"errors"
+	"math"
+	"strings"
+	"testing"
+)
+
+var tokenTests = []struct {
+	name          string
+	alphabet      string
+	length        int
+	entropy       float64
+	errorExpected bool
+}{
+	{name: "default", alphabet: "", length: 25, entropy: 150},
+	{name: "hex", alphabet: AlphabetHex, length: 32, entropy: 128},
+	{name: "crockford", alphabet: AlphabetCrockford, length: 26, entropy: 130},
+	{name: "url safe", alphabet: AlphabetURLSafe, length: 22, entropy: 132},
+	{name: "digits", alphabet: AlphabetDigits, length: 6, entropy: 6 * math.Log2(10)},
+	{name: "no lookalikes", alphabet: AlphabetNoLookalikes, length: 20, entropy: 20 * math.Log2(56)},
+	{name: "too short", alphabet: "a", length: 10, errorExpected: true},
+	{name: "duplicates", alphabet: "abca", length: 10, errorExpected: true},
+	{name: "not ascii", alphabet: "abcé", length: 10, errorExpected: true},
+}
+
+func TestTokenGenerator_Generate(t *testing.T) {
+	for _, e := range tokenTests {
+		g := TokenGenerator{Alphabet: e.alphabet}
+		token, err := g.Generate(e.length)
+		if e.errorExpected {
+			if err == nil {
+				t.Errorf("%s - Error expected, but got none.", e.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s - Error not expected, but got one : %s", e.name, err)
+			continue
+		}
+		if len(token) != e.length {
+			t.Errorf("%s - Wrong token length, expected %d but got %d", e.name, e.length, len(token))
+		}
+		alphabet := e.alphabet
+		if alphabet == "" {
+			alphabet = AlphabetDefault
+		}
+		if strings.Trim(token, alphabet) != "" {
+			t.Errorf("%s - Token %s contains characters outside of the alphabet", e.name, token)
+		}
+		if math.Abs(g.EntropyBits(e.length)-e.entropy) > 1e-9 {
+			t.Errorf("%s - Wrong entropy, expected %f but got %f", e.name, e.entropy, g.EntropyBits(e.length))
+		}
+		if g.LengthFor(e.entropy) != e.length {
+			t.Errorf("%s - Wrong length for %f bits: %d", e.name, e.entropy, g.LengthFor(e.entropy))
+		}
+	}
+}
+
+func TestTokenGenerator_Uniform(t *testing.T) {
+	g := TokenGenerator{Alphabet: "abcde"}
+	token, err := g.Generate(50000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	counts := make(map[rune]int)
+	for _, c := range token {
+		counts[c]++
+	}
+	expected := float64(len(token)) / 5
+	var chi2 float64
+	for _, c := range g.Alphabet {
+		d := float64(counts[c]) - expected
+		chi2 += d * d / expected
+	}
+	// 4 degrees of freedom, p = 0.001
+	if chi2 > 18.47 {
+		t.Errorf("Distribution is not uniform, chi-square is %f: %v", chi2, counts)
+	}
+}
+
+type failingReader struct{}
+
+func (failingReader) Read([]byte) (int, error) {
+	return 0, errors.New("entropy source failed")
+}
+
+func TestTokenGenerator_ReaderError(t *testing.T) {
+	g := TokenGenerator{Reader: failingReader{}}
+	if _, err := g.Generate(10); err == nil {
+		t.Error("Error expected when the random source fails, but got none.")
+	}
+}
@@ -2,41 +2,65 @@ package toolkit
 
 import (
 	"bytes"
-	"crypto/rand"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"log/slog"
+	"mime"
+	"mime/multipart"
 	"net/http"
 	"os"
 	"path/filepath"
-	"regexp"
+	"strconv"
 	"strings"
+	"time"
 )
 
+// Tools is configured with New and its options, and the configuration cannot
+// be changed afterwards, so a *Tools can be shared between goroutines. The
+// settings can be read through the accessors of the same name. The zero value
+// is ready to use, with the default of every setting.
 type Tools struct {
-	MaxFileSize        int64
-	AllowedTypes       []string
-	MaxJSONSize        int
-	AllowUnknownFields bool
+	maxFileSize        int64
+	allowedTypes       []string
+	maxJSONSize        int
+	allowUnknownFields bool
+	uploadAllOrNothing bool
+	scanner            Scanner
+	quarantineDir      string
+	quota              *Quota
+	onUploadProgress   func(UploadProgress)
+	progressTracker    *ProgressTracker
+	uploadRateLimit    int64
+	uploadIDScheme     IDScheme
+	passwordHasher     PasswordHasher
+	logger             *slog.Logger
+	metrics            Metrics
+	useProblemJSON     bool
+	requestIDHeader    string
+	requestIDScheme    IDScheme
+	remoteRetry        *RetryPolicy
 }
 
 const randomStringSource = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz_+"
 
+// GenerateRandomString panics if the system random source fails; use
+// GenerateToken to get the error instead.
 func (t *Tools) GenerateRandomString(n int) string {
-	s, r := make([]rune, n), []rune(randomStringSource)
-	for i := range s {
-		p, _ := rand.Prime(rand.Reader, len(r))
-		x, y := p.Uint64(), uint64(len(r))
-		s[i] = r[x%y]
+	s, err := TokenGenerator{}.Generate(n)
+	if err != nil {
+		panic(err)
 	}
-	return string(s)
+	return s
 }
 
 type UploadedFile struct {
 	NewFileName      string
 	OriginalFileName string
 	FileSize         int64
+	Status           ScanStatus
 }
 
 func (t *Tools) UploadOneFile(r *http.Request, uploadDir string, rename ...bool) (*UploadedFile, error) {
@@ -56,84 +80,262 @@ func (t *Tools) UploadFiles(r *http.Request, uploadDir string, rename ...bool) (
 	if len(rename) > 0 {
 		renameFile = rename[0]
 	}
-	var uploadedFiles []*UploadedFile
-
-	if t.MaxFileSize == 0 {
-		t.MaxFileSize = 1024 * 1024 * 1024
+	progress := t.newProgressReporter(r)
+	if progress != nil {
+		r.Body = &progressBody{ReadCloser: r.Body, progress: progress}
 	}
+	uploadedFiles, err := t.uploadFiles(r, uploadDir, renameFile, progress)
+	progress.done(err)
+	return uploadedFiles, err
+}
+
+func (t *Tools) uploadFiles(r *http.Request, uploadDir string, renameFile bool, progress *progressReporter) ([]*UploadedFile, error) {
+	var uploadedFiles []*UploadedFile
+	var staged []*stagedUpload
+	throttle := &uploadThrottle{rate: t.uploadRateLimit}
 
 	err := t.CreateDirIfNotExist(uploadDir)
 	if err != nil {
 		return nil, err
 	}
-	err = r.ParseMultipartForm(t.MaxFileSize)
+	if t.scanner != nil {
+		if err = t.CreateDirIfNotExist(t.quarantinePath(uploadDir)); err != nil {
+			return nil, err
+		}
+	}
+	err = r.ParseMultipartForm(t.MaxFileSize())
 	if err != nil {
-		return nil, errors.New("File size is too big")
+		t.recordUploadRejected(r.Context(), "", r.ContentLength, errFileTooBig)
+		return nil, errFileTooBig
+	}
+
+	fail := func(err error) ([]*UploadedFile, error) {
+		if t.uploadAllOrNothing {
+			discardStaged(staged)
+			return nil, err
+		}
+		return uploadedFiles, err
+	}
+	var owner string
+	if t.quota != nil {
+		owner = t.quota.Owner(r)
 	}
 
 	for _, fHeaders := range r.MultipartForm.File {
 		for _, hdr := range fHeaders {
-			uploadedFiles, err = func([]*UploadedFile) ([]*UploadedFile, error) {
-				var uploadedFile UploadedFile
-				infile, err := hdr.Open()
-				if err != nil {
-					return nil, err
-				}
-				defer infile.Close()
-				buff := make([]byte, 512)
-				_, err = infile.Read(buff)
-				if err != nil {
-					return nil, err
-				}
-				allowed := false
-				filetype := http.DetectContentType(buff)
-				if len(t.AllowedTypes) > 0 {
-					for _, x := range t.AllowedTypes {
-						if strings.EqualFold(x, filetype) {
-							allowed = true
-							break
-						}
-					}
-				} else {
-					allowed = true
-				}
-				if !allowed {
-					return nil, errors.New("File type is not allowed")
-				}
-
-				_, err = infile.Seek(0, 0)
-				if err != nil {
-					return nil, err
-				}
-				if renameFile {
-					uploadedFile.NewFileName = fmt.Sprintf("%s%s", t.GenerateRandomString(25), filepath.Ext(hdr.Filename))
-				} else {
-					uploadedFile.NewFileName = hdr.Filename
+			var release func()
+			if t.quota != nil {
+				if err = t.quota.Reserve(owner, uploadDir, hdr.Size); err != nil {
+					t.recordUploadRejected(r.Context(), hdr.Filename, hdr.Size, err)
+					return fail(err)
 				}
-				uploadedFile.OriginalFileName = hdr.Filename
-				var outfile *os.File
-				defer outfile.Close()
-
-				if outfile, err = os.Create(filepath.Join(uploadDir, uploadedFile.NewFileName)); err != nil {
-					return nil, err
-				} else {
-					fileSize, err := io.Copy(outfile, infile)
-					if err != nil {
-						return nil, err
-					}
-					uploadedFile.FileSize = fileSize
-				}
-				uploadedFiles = append(uploadedFiles, &uploadedFile)
-				return uploadedFiles, nil
-			}(uploadedFiles)
+				size := hdr.Size
+				release = func() { _ = t.quota.Release(owner, uploadDir, size) }
+			}
+			s, err := t.stageUpload(r.Context(), hdr, uploadDir, renameFile, progress, throttle)
 			if err != nil {
+				if release != nil {
+					release()
+				}
+				t.recordUploadRejected(r.Context(), hdr.Filename, hdr.Size, err)
+				return fail(err)
+			}
+			s.release = release
+			if t.uploadAllOrNothing {
+				staged = append(staged, s)
+				continue
+			}
+			if err = s.commit(); err != nil {
+				s.discard()
+				t.recordUploadRejected(r.Context(), hdr.Filename, hdr.Size, err)
 				return uploadedFiles, err
 			}
+			t.recordUploadAccepted(r.Context(), uploadDir, s.file, s.contentType)
+			uploadedFiles = append(uploadedFiles, s.file)
+		}
+	}
+
+	for i, s := range staged {
+		if err = s.commit(); err != nil {
+			for _, c := range staged[:i] {
+				c.rollback()
+			}
+			discardStaged(staged[i:])
+			t.recordUploadRejected(r.Context(), s.file.OriginalFileName, s.file.FileSize, err)
+			return nil, err
 		}
+		uploadedFiles = append(uploadedFiles, s.file)
+	}
+	for _, s := range staged {
+		t.recordUploadAccepted(r.Context(), uploadDir, s.file, s.contentType)
 	}
 	return uploadedFiles, nil
 }
 
+type stagedUpload struct {
+	file        *UploadedFile
+	contentType string
+	tmpPath     string
+	finalPath   string
+	release     func()
+}
+
+func (s *stagedUpload) commit() error {
+	dir := filepath.Dir(s.finalPath)
+	name, err := moveUnique(s.tmpPath, dir, filepath.Base(s.finalPath))
+	if err != nil {
+		return err
+	}
+	s.finalPath = filepath.Join(dir, name)
+	s.file.NewFileName = name
+	return nil
+}
+
+func (s *stagedUpload) discard() {
+	_ = os.Remove(s.tmpPath)
+	if s.release != nil {
+		s.release()
+	}
+}
+
+func (s *stagedUpload) rollback() {
+	_ = os.Remove(s.finalPath)
+	if s.release != nil {
+		s.release()
+	}
+}
+
+func discardStaged(staged []*stagedUpload) {
+	for _, s := range staged {
+		s.discard()
+	}
+}
+
+func (t *Tools) stageUpload(ctx context.Context, hdr *multipart.FileHeader, uploadDir string, renameFile bool, progress *progressReporter, throttle *uploadThrottle) (*stagedUpload, error) {
+	var uploadedFile UploadedFile
+	infile, err := hdr.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer infile.Close()
+	buff := make([]byte, 512)
+	_, err = infile.Read(buff)
+	if err != nil {
+		return nil, err
+	}
+	contentType, err := t.checkFileType(buff)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = infile.Seek(0, 0)
+	if err != nil {
+		return nil, err
+	}
+	if renameFile {
+		id, err := t.NewID(t.uploadIDScheme)
+		if err != nil {
+			return nil, err
+		}
+		uploadedFile.NewFileName = fmt.Sprintf("%s%s", id, filepath.Ext(hdr.Filename))
+	} else {
+		uploadedFile.NewFileName, err = t.SanitizeFilename(hdr.Filename)
+		if err != nil {
+			id, err := t.NewID(t.uploadIDScheme)
+			if err != nil {
+				return nil, err
+			}
+			uploadedFile.NewFileName = id
+		}
+	}
+	uploadedFile.OriginalFileName = hdr.Filename
+
+	// The file is written under a temporary name and only renamed into place
+	// once it is complete, so a failed copy never leaves a truncated file.
+	// When a scanner is configured the temporary file lives in quarantine
+	// until the scan has passed.
+	stagingDir := uploadDir
+	if t.scanner != nil {
+		stagingDir = t.quarantinePath(uploadDir)
+	}
+	outfile, err := os.CreateTemp(stagingDir, ".upload-*.tmp")
+	if err != nil {
+		return nil, err
+	}
+	if err = outfile.Chmod(0644); err != nil {
+		outfile.Close()
+		_ = os.Remove(outfile.Name())
+		return nil, err
+	}
+	s := &stagedUpload{
+		file:        &uploadedFile,
+		contentType: contentType,
+		tmpPath:     outfile.Name(),
+		finalPath:   filepath.Join(uploadDir, uploadedFile.NewFileName),
+	}
+	writer := &uploadWriter{
+		w:        outfile,
+		ctx:      ctx,
+		fileName: uploadedFile.OriginalFileName,
+		progress: progress,
+		throttle: throttle,
+	}
+	fileSize, err := io.Copy(writer, infile)
+	if cerr := outfile.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		s.discard()
+		return nil, err
+	}
+	uploadedFile.FileSize = fileSize
+
+	if t.scanner != nil {
+		if err = t.scanFile(ctx, s.tmpPath, &uploadedFile); err != nil {
+			var infected *InfectedFileError
+			if errors.As(err, &infected) {
+				if name, err := moveUnique(s.tmpPath, stagingDir, uploadedFile.NewFileName); err == nil {
+					uploadedFile.NewFileName = name
+				}
+			} else {
+				s.discard()
+			}
+			return nil, err
+		}
+		if uploadedFile.Status == ScanPending {
+			s.finalPath = filepath.Join(stagingDir, uploadedFile.NewFileName)
+		}
+	}
+	return s, nil
+}
+
+var errFileTooBig = errors.New("File size is too big")
+
+// FileTypeError is returned for uploads whose detected content type is not one
+// of AllowedTypes.
+type FileTypeError struct {
+	ContentType string
+}
+
+func (e *FileTypeError) Error() string {
+	return "File type is not allowed"
+}
+
+// checkFileType returns the content type detected from the first bytes of a
+// file, and an error if it is not allowed.
+func (t *Tools) checkFileType(buff []byte) (string, error) {
+	filetype := http.DetectContentType(buff)
+	if len(t.allowedTypes) == 0 {
+		return filetype, nil
+	}
+	for _, x := range t.allowedTypes {
+		if strings.EqualFold(x, filetype) {
+			return filetype, nil
+		}
+	}
+	return filetype, &FileTypeError{ContentType: filetype}
+}
+
 func (t *Tools) CreateDirIfNotExist(path string) error {
 	const mode = 0755
 	if _, err := os.Stat(path); os.IsNotExist(err) {
@@ -145,38 +347,42 @@ func (t *Tools) CreateDirIfNotExist(path string) error {
 	return nil
 }
 
-func (t *Tools) Slugify(s string) (string, error) {
-	if s == "" {
-		return "", errors.New("String is empty")
-	}
-	re := regexp.MustCompile(`[^a-z\d]+`)
-	slug := strings.Trim(re.ReplaceAllString(strings.ToLower(s), "-"), "-")
-	if len(slug) == 0 {
-		return "", errors.New("Slug is empty")
-	}
-	return slug, nil
-}
-
+// DownloadtaticFile serves file f of directory p as an attachment named
+// displayName. The name is escaped, so it may hold quotes or any other
+// character.
 func (t *Tools) DownloadtaticFile(w http.ResponseWriter, r *http.Request, p, f, displayName string) {
 	pathname := filepath.Join(p, f)
-	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s\"", displayName))
+	disposition := mime.FormatMediaType("attachment", map[string]string{"filename": displayName})
+	if disposition == "" {
+		disposition = "attachment"
+	}
+	w.Header().Set("Content-Disposition", disposition)
 	http.ServeFile(w, r, pathname)
 }
 
 type JSONResponse struct {
-	Error   bool        `json:"error"`
-	Message string      `json:"message"`
-	Data    interface{} `json:"data,omitempty"`
+	Error     bool        `json:"error"`
+	Message   string      `json:"message"`
+	Data      interface{} `json:"data,omitempty"`
+	RequestID string      `json:"request_id,omitempty"`
 }
 
 func (t *Tools) ReadJSON(w http.ResponseWriter, r http.Request, data interface{}) error {
-	maxBytes := 1024 ^ 2
-	if t.MaxJSONSize == 0 {
-		maxBytes = t.MaxJSONSize
+	err := t.readJSON(w, &r, data)
+	if err != nil {
+		t.log(r.Context(), slog.LevelInfo, "JSON decode failed",
+			slog.String("method", r.Method),
+			slog.String("path", r.URL.Path),
+			slog.String("reason", err.Error()))
 	}
+	return err
+}
+
+func (t *Tools) readJSON(w http.ResponseWriter, r *http.Request, data interface{}) error {
+	maxBytes := t.MaxJSONSize()
 	r.Body = http.MaxBytesReader(w, r.Body, int64(maxBytes))
 	dec := json.NewDecoder(r.Body)
-	if !t.AllowUnknownFields {
+	if !t.allowUnknownFields {
 		dec.DisallowUnknownFields()
 	}
 	err := dec.Decode(&data)
@@ -216,6 +422,10 @@ func (t *Tools) ReadJSON(w http.ResponseWriter, r http.Request, data interface{}
 }
 
 func (t *Tools) WriteJSON(w http.ResponseWriter, status int, data interface{}, headers ...http.Header) error {
+	return t.writeJSON(w, status, "application/json", data, headers...)
+}
+
+func (t *Tools) writeJSON(w http.ResponseWriter, status int, contentType string, data interface{}, headers ...http.Header) error {
 	out, err := json.Marshal(data)
 	if err != nil {
 		return err
@@ -225,8 +435,9 @@ func (t *Tools) WriteJSON(w http.ResponseWriter, status int, data interface{}, h
 			w.Header()[key] = value
 		}
 	}
-	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Type", contentType)
 	w.WriteHeader(status)
+	t.Metrics().Add(MetricJSONResponses, 1, Label{Name: "status", Value: strconv.Itoa(status)})
 	_, err = w.Write(out)
 	if err != nil {
 		return err
@@ -242,10 +453,74 @@ func (t *Tools) ErrorJSON(w http.ResponseWriter, err error, status ...int) error
 	var payload JSONResponse
 	payload.Error = true
 	payload.Message = err.Error()
+	payload.RequestID = w.Header().Get(t.RequestIDHeader())
 	return t.WriteJSON(w, statusCode, payload)
 }
 
 func (t *Tools) PushJSONToRemote(uri string, data interface{}, client ...*http.Client) (*http.Response, int, error) {
+	return t.PushJSONToRemoteContext(context.Background(), uri, data, client...)
+}
+
+// RetryPolicy makes PushJSONToRemote retry requests that fail with a network
+// error or a 429, 502, 503 or 504 status, up to MaxAttempts attempts in all.
+// It waits Backoff before the first retry and twice as long before each of the
+// next, up to MaxBackoff when it is set, or longer when the response has a
+// Retry-After header. A Retry-After longer than MaxBackoff, or than a minute
+// when MaxBackoff is not set, is not waited for: the response is returned as
+// is. With IdempotencyKeys, every attempt carries the same
+// Idempotency-Key header so that the remote service can tell a retry from a
+// new request.
+type RetryPolicy struct {
+	MaxAttempts     int
+	Backoff         time.Duration
+	MaxBackoff      time.Duration
+	IdempotencyKeys bool
+}
+
+// maxRetryAfter is the longest Retry-After waited for when MaxBackoff is not
+// set.
+const maxRetryAfter = time.Minute
+
+func (p *RetryPolicy) retryable(status int, err error) bool {
+	if err != nil {
+		return true
+	}
+	switch status {
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// wait returns how long to wait before the next attempt, or false when the
+// remote service asks for a longer wait than the policy allows.
+func (p *RetryPolicy) wait(attempt int, res *http.Response) (time.Duration, bool) {
+	wait := p.Backoff
+	for i := 1; i < attempt && (p.MaxBackoff <= 0 || wait < p.MaxBackoff); i++ {
+		wait *= 2
+	}
+	if p.MaxBackoff > 0 && wait > p.MaxBackoff {
+		wait = p.MaxBackoff
+	}
+	if res != nil {
+		if seconds, err := strconv.Atoi(res.Header.Get("Retry-After")); err == nil && time.Duration(seconds)*time.Second > wait {
+			limit := p.MaxBackoff
+			if limit <= 0 {
+				limit = maxRetryAfter
+			}
+			if seconds > int(limit/time.Second) {
+				return 0, false
+			}
+			wait = time.Duration(seconds) * time.Second
+		}
+	}
+	return wait, true
+}
+
+// PushJSONToRemoteContext is PushJSONToRemote with a context, from which the
+// request ID and trace context set by the RequestID middleware are forwarded.
+// The context also bounds the retries made under RemoteRetry.
+func (t *Tools) PushJSONToRemoteContext(ctx context.Context, uri string, data interface{}, client ...*http.Client) (*http.Response, int, error) {
 	jsonData, err := json.Marshal(data)
 	if err != nil {
 		return nil, 0, err
@@ -256,18 +531,57 @@ func (t *Tools) PushJSONToRemote(uri string, data interface{}, client ...*http.C
 		httpClient = *client[0]
 	}
 
-	req, err := http.NewRequest("POST", uri, bytes.NewBuffer(jsonData))
-	if err != nil {
-		return nil, 0, err
+	attempts := 1
+	var idempotencyKey string
+	if t.remoteRetry != nil {
+		attempts = max(t.remoteRetry.MaxAttempts, 1)
+		if t.remoteRetry.IdempotencyKeys {
+			if idempotencyKey, err = t.NewID(IDUUIDv4); err != nil {
+				return nil, 0, err
+			}
+		}
 	}
 
-	req.Header.Set("Content-Type", "application/json")
-	res, err := httpClient.Do(req)
-	if err != nil {
-		return nil, 0, fmt.Errorf("Error sending request to remote server - %s", err)
-	}
+	for attempt := 1; ; attempt++ {
+		req, err := http.NewRequestWithContext(ctx, "POST", uri, bytes.NewReader(jsonData))
+		if err != nil {
+			return nil, 0, err
+		}
 
-	defer req.Body.Close()
+		req.Header.Set("Content-Type", "application/json")
+		if idempotencyKey != "" {
+			req.Header.Set("Idempotency-Key", idempotencyKey)
+		}
+		t.setCorrelationHeaders(ctx, req)
+		start := time.Now()
+		res, err := httpClient.Do(req)
+		status := 0
+		if err == nil {
+			status = res.StatusCode
+		}
+		t.recordRemoteCall(req.Context(), req.URL, status, time.Since(start), err)
 
-	return res, res.StatusCode, nil
+		retry := attempt < attempts && t.remoteRetry.retryable(status, err) && ctx.Err() == nil
+		var wait time.Duration
+		if retry {
+			wait, retry = t.remoteRetry.wait(attempt, res)
+		}
+		if !retry {
+			if err != nil {
+				return nil, 0, fmt.Errorf("Error sending request to remote server - %s", err)
+			}
+			return res, res.StatusCode, nil
+		}
+		if res != nil {
+			_, _ = io.Copy(io.Discard, io.LimitReader(res.Body, 4096))
+			res.Body.Close()
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, 0, fmt.Errorf("Error sending request to remote server - %s", ctx.Err())
+		case <-timer.C:
+		}
+	}
 }
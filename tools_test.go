@@ -8,10 +8,12 @@ import (
 	"image"
 	"image/png"
 	"io"
+	"mime"
 	"mime/multipart"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"strings"
 	"sync"
 	"testing"
 )
@@ -77,7 +79,7 @@ func TestTools_UploadFiles(t *testing.T) {
 		request := httptest.NewRequest("POST", "/", pr)
 		request.Header.Add("Content-Type", writer.FormDataContentType())
 		var testTools Tools
-		testTools.AllowedTypes = e.allowedTypes
+		testTools.allowedTypes = e.allowedTypes
 		uploadedFiles, err := testTools.UploadFiles(request, "./testdata/uploads", e.renameFile)
 		if err != nil && !e.errorExpected {
 			t.Error(err)
@@ -95,6 +97,83 @@ func TestTools_UploadFiles(t *testing.T) {
 	}
 }
 
+func newMultipartRequest(t *testing.T, files map[string][]byte, order ...string) *http.Request {
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	for _, name := range order {
+		part, err := writer.CreateFormFile("file", name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = part.Write(files[name]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	request := httptest.NewRequest("POST", "/", body)
+	request.Header.Add("Content-Type", writer.FormDataContentType())
+	return request
+}
+
+var atomicUploadTests = []struct {
+	name          string
+	allOrNothing  bool
+	expectedFiles int
+}{
+	{
+		name:          "keep files before failure",
+		allOrNothing:  false,
+		expectedFiles: 2,
+	},
+	{
+		name:          "all or nothing",
+		allOrNothing:  true,
+		expectedFiles: 0,
+	},
+}
+
+func TestTools_UploadFilesAtomic(t *testing.T) {
+	img, err := os.ReadFile("./testdata/img.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := map[string][]byte{
+		"one.png":   img,
+		"two.png":   img,
+		"three.txt": []byte("plain text is not allowed"),
+	}
+
+	for _, e := range atomicUploadTests {
+		dir := t.TempDir()
+		var testTools Tools
+		testTools.allowedTypes = []string{"image/png"}
+		testTools.uploadAllOrNothing = e.allOrNothing
+		request := newMultipartRequest(t, files, "one.png", "two.png", "three.txt")
+
+		uploadedFiles, err := testTools.UploadFiles(request, dir)
+		if err == nil {
+			t.Errorf("%s - Error expected, but got none.", e.name)
+		}
+		if len(uploadedFiles) != e.expectedFiles {
+			t.Errorf("%s - Expected %d uploaded files, got %d", e.name, e.expectedFiles, len(uploadedFiles))
+		}
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(entries) != e.expectedFiles {
+			t.Errorf("%s - Expected %d files on disk, got %d", e.name, e.expectedFiles, len(entries))
+		}
+		for _, entry := range entries {
+			if strings.HasSuffix(entry.Name(), ".tmp") {
+				t.Errorf("%s - Temporary file %s left on disk", e.name, entry.Name())
+			}
+		}
+	}
+}
+
 func TestTools_UploadOneFile(t *testing.T) {
 	pr, pw := io.Pipe()
 	writer := multipart.NewWriter(pw)
@@ -205,10 +284,16 @@ func TestTools_DownloadStaticFile(t *testing.T) {
 	if res.Header["Content-Length"][0] != "4506" {
 		t.Error("Wrong content length, actual length : ", res.Header["Content-Length"][0])
 	}
-	if res.Header["Content-Disposition"][0] != "attachment; filename=\"pop.go\"" {
+	if res.Header["Content-Disposition"][0] != "attachment; filename=pop.go" {
 		t.Error("Wrong content disposition, actual disposition : ", res.Header["Content-Disposition"][0])
 	}
 
+	rr = httptest.NewRecorder()
+	testools.DownloadtaticFile(rr, req, "./testdata/", "FileToDownload.go", "a\"\r\nX-Evil: 1; b=c.go")
+	if _, params, err := mime.ParseMediaType(rr.Header().Get("Content-Disposition")); err != nil || params["filename"] != "a\"\r\nX-Evil: 1; b=c.go" || len(params) != 1 {
+		t.Error("Display name not escaped, actual disposition : ", rr.Header().Get("Content-Disposition"))
+	}
+
 	_, err := io.ReadAll(res.Body)
 	if err != nil {
 		t.Error(err)
@@ -291,8 +376,8 @@ func TestTools_ReadJSON(t *testing.T) {
 	var testTools Tools
 
 	for _, e := range jsonTests {
-		testTools.MaxJSONSize = e.maxSize
-		testTools.AllowUnknownFields = e.allowUnknown
+		testTools.maxJSONSize = e.maxSize
+		testTools.allowUnknownFields = e.allowUnknown
 		var decodedJSON struct {
 			Name string `json:"name"`
 			Age  int    `json:"age"`
@@ -363,6 +448,19 @@ func NewTestClient(fn RoundTripFunc) *http.Client {
 	}
 }
 
+// RoundTripErrorFunc is a RoundTripFunc that can fail like a transport does.
+type RoundTripErrorFunc func(req *http.Request) (*http.Response, error)
+
+func (f RoundTripErrorFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+func NewTestClientWithError(fn RoundTripErrorFunc) *http.Client {
+	return &http.Client{
+		Transport: fn,
+	}
+}
+
 func TestTools_PushJSONToRemote(t *testing.T) {
 	client := NewTestClient(func(req *http.Request) *http.Response {
 		return &http.Response{
@@ -0,0 +1,490 @@
+package toolkit
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const tusVersion = "1.0.0"
+
+// TusHandler serves resumable uploads using the core tus protocol together with
+// the creation, expiration and termination extensions. Completed uploads are
+// moved into UploadDir and checked against the AllowedTypes and MaxFileSize of
+// the Tools that created the handler.
+type TusHandler struct {
+	BasePath     string
+	UploadDir    string
+	Expiration   time.Duration
+	KeepFileName bool
+	OnComplete   func(r *http.Request, file *UploadedFile)
+
+	tools *Tools
+	locks sync.Map
+}
+
+type tusUpload struct {
+	ID       string        `json:"id"`
+	Length   int64         `json:"length"`
+	Metadata string        `json:"metadata,omitempty"`
+	Owner    string        `json:"owner,omitempty"`
+	Expires  time.Time     `json:"expires"`
+	Checked  bool          `json:"checked"`
+	Type     string        `json:"type,omitempty"`
+	File     *UploadedFile `json:"file,omitempty"`
+}
+
+func (t *Tools) NewTusHandler(uploadDir, basePath string) *TusHandler {
+	return &TusHandler{
+		BasePath:   basePath,
+		UploadDir:  uploadDir,
+		Expiration: 24 * time.Hour,
+		tools:      t,
+	}
+}
+
+func (h *TusHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Tus-Resumable", tusVersion)
+	if r.Method == http.MethodOptions {
+		w.Header().Set("Tus-Version", tusVersion)
+		w.Header().Set("Tus-Extension", "creation,expiration,termination")
+		w.Header().Set("Tus-Max-Size", strconv.FormatInt(h.tools.MaxFileSize(), 10))
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if r.Header.Get("Tus-Resumable") != tusVersion {
+		w.Header().Set("Tus-Version", tusVersion)
+		h.error(w, r, errors.New("Unsupported tus protocol version"), http.StatusPreconditionFailed)
+		return
+	}
+
+	id := strings.Trim(strings.TrimPrefix(r.URL.Path, h.BasePath), "/")
+	switch {
+	case id == "" && r.Method == http.MethodPost:
+		h.create(w, r)
+	case id == "":
+		w.Header().Set("Allow", "OPTIONS, POST")
+		h.error(w, r, errors.New("Method not allowed"), http.StatusMethodNotAllowed)
+	case r.Method == http.MethodHead:
+		h.head(w, r, id)
+	case r.Method == http.MethodPatch:
+		h.patch(w, r, id)
+	case r.Method == http.MethodDelete:
+		h.terminate(w, r, id)
+	default:
+		w.Header().Set("Allow", "OPTIONS, HEAD, PATCH, DELETE")
+		h.error(w, r, errors.New("Method not allowed"), http.StatusMethodNotAllowed)
+	}
+}
+
+func (h *TusHandler) create(w http.ResponseWriter, r *http.Request) {
+	length, err := strconv.ParseInt(r.Header.Get("Upload-Length"), 10, 64)
+	if err != nil || length < 0 {
+		h.error(w, r, errors.New("Upload-Length header is invalid"), http.StatusBadRequest)
+		return
+	}
+	if length > h.tools.MaxFileSize() {
+		h.error(w, r, errors.New("File size is too big"), http.StatusRequestEntityTooLarge)
+		return
+	}
+	metadata := r.Header.Get("Upload-Metadata")
+	if _, err = parseTusMetadata(metadata); err != nil {
+		h.error(w, r, err, http.StatusBadRequest)
+		return
+	}
+	if err = h.tools.CreateDirIfNotExist(h.UploadDir); err != nil {
+		h.error(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	u := &tusUpload{
+		ID:       h.tools.GenerateRandomString(25),
+		Length:   length,
+		Metadata: metadata,
+	}
+	if h.Expiration > 0 {
+		u.Expires = time.Now().Add(h.Expiration).UTC()
+	}
+	if h.tools.quota != nil {
+		u.Owner = h.tools.quota.Owner(r)
+		if err = h.tools.quota.Reserve(u.Owner, h.UploadDir, length); err != nil {
+			h.tools.recordUploadRejected(r.Context(), h.fileName(u), length, err)
+			h.error(w, r, err, http.StatusRequestEntityTooLarge)
+			return
+		}
+	}
+	f, err := os.OpenFile(h.partPath(u.ID), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	if err != nil {
+		h.release(u)
+		h.error(w, r, err, http.StatusInternalServerError)
+		return
+	}
+	_ = f.Close()
+	if err = h.save(u); err != nil {
+		h.remove(u)
+		h.error(w, r, err, http.StatusInternalServerError)
+		return
+	}
+	if length == 0 {
+		if status, err := h.checkType(r, u); err != nil {
+			h.error(w, r, err, status)
+			return
+		}
+		if status, err := h.finish(r, u); err != nil {
+			h.error(w, r, err, status)
+			return
+		}
+	}
+
+	w.Header().Set("Location", path.Join(h.BasePath, u.ID))
+	h.setExpires(w, u)
+	w.WriteHeader(http.StatusCreated)
+}
+
+func (h *TusHandler) head(w http.ResponseWriter, r *http.Request, id string) {
+	u, status, err := h.load(id)
+	if err != nil {
+		h.error(w, r, err, status)
+		return
+	}
+	offset, err := h.offset(u)
+	if err != nil {
+		h.error(w, r, err, http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Upload-Offset", strconv.FormatInt(offset, 10))
+	w.Header().Set("Upload-Length", strconv.FormatInt(u.Length, 10))
+	w.Header().Set("Cache-Control", "no-store")
+	if u.Metadata != "" {
+		w.Header().Set("Upload-Metadata", u.Metadata)
+	}
+	h.setExpires(w, u)
+	w.WriteHeader(http.StatusOK)
+}
+
+func (h *TusHandler) patch(w http.ResponseWriter, r *http.Request, id string) {
+	if r.Header.Get("Content-Type") != "application/offset+octet-stream" {
+		h.error(w, r, errors.New("Content-Type must be application/offset+octet-stream"), http.StatusUnsupportedMediaType)
+		return
+	}
+	offset, err := strconv.ParseInt(r.Header.Get("Upload-Offset"), 10, 64)
+	if err != nil || offset < 0 {
+		h.error(w, r, errors.New("Upload-Offset header is invalid"), http.StatusBadRequest)
+		return
+	}
+	unlock, ok := h.lock(id)
+	if !ok {
+		h.error(w, r, errors.New("Upload is locked by another request"), http.StatusLocked)
+		return
+	}
+	defer unlock()
+
+	u, status, err := h.load(id)
+	if err != nil {
+		h.error(w, r, err, status)
+		return
+	}
+	current, err := h.offset(u)
+	if err != nil {
+		h.error(w, r, err, http.StatusInternalServerError)
+		return
+	}
+	if offset != current {
+		h.error(w, r, fmt.Errorf("Upload-Offset does not match the current offset %d", current), http.StatusConflict)
+		return
+	}
+	if u.File != nil {
+		// A repeat of the final PATCH, whose response the client did not get.
+		w.Header().Set("Upload-Offset", strconv.FormatInt(current, 10))
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	f, err := os.OpenFile(h.partPath(u.ID), os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		h.error(w, r, err, http.StatusInternalServerError)
+		return
+	}
+	n, err := io.Copy(f, io.LimitReader(r.Body, u.Length-current))
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	current += n
+	if err != nil {
+		h.error(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	if !u.Checked && (current >= 512 || current == u.Length) {
+		if status, err := h.checkType(r, u); err != nil {
+			h.error(w, r, err, status)
+			return
+		}
+	}
+	if current == u.Length {
+		if status, err := h.finish(r, u); err != nil {
+			h.error(w, r, err, status)
+			return
+		}
+	}
+
+	w.Header().Set("Upload-Offset", strconv.FormatInt(current, 10))
+	h.setExpires(w, u)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *TusHandler) terminate(w http.ResponseWriter, r *http.Request, id string) {
+	unlock, ok := h.lock(id)
+	if !ok {
+		h.error(w, r, errors.New("Upload is locked by another request"), http.StatusLocked)
+		return
+	}
+	defer unlock()
+
+	u, status, err := h.load(id)
+	if err != nil {
+		h.error(w, r, err, status)
+		return
+	}
+	h.remove(u)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// CleanupExpired removes every upload whose expiration date has passed. It is
+// meant to be called periodically, since expired uploads are otherwise only
+// removed when a client tries to access them.
+func (h *TusHandler) CleanupExpired() error {
+	entries, err := os.ReadDir(h.UploadDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	for _, entry := range entries {
+		name := entry.Name()
+		if !strings.HasPrefix(name, ".tus-") || !strings.HasSuffix(name, ".info") {
+			continue
+		}
+		id := strings.TrimSuffix(strings.TrimPrefix(name, ".tus-"), ".info")
+		if _, status, err := h.load(id); err != nil && status != http.StatusGone {
+			return err
+		}
+	}
+	return nil
+}
+
+func (h *TusHandler) checkType(r *http.Request, u *tusUpload) (int, error) {
+	f, err := os.Open(h.partPath(u.ID))
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	defer f.Close()
+	buff := make([]byte, 512)
+	n, err := f.Read(buff)
+	if err != nil && err != io.EOF {
+		return http.StatusInternalServerError, err
+	}
+	if u.Type, err = h.tools.checkFileType(buff[:n]); err != nil {
+		h.remove(u)
+		h.tools.recordUploadRejected(r.Context(), h.fileName(u), u.Length, err)
+		return http.StatusUnsupportedMediaType, err
+	}
+	u.Checked = true
+	if err = h.save(u); err != nil {
+		return http.StatusInternalServerError, err
+	}
+	return 0, nil
+}
+
+func (h *TusHandler) finish(r *http.Request, u *tusUpload) (int, error) {
+	name := h.fileName(u)
+
+	file := &UploadedFile{
+		OriginalFileName: name,
+		FileSize:         u.Length,
+	}
+	if h.KeepFileName && name != "" {
+		sanitized, err := h.tools.SanitizeFilename(name)
+		if err != nil {
+			return http.StatusBadRequest, err
+		}
+		file.NewFileName = sanitized
+	} else {
+		id, err := h.tools.NewID(h.tools.uploadIDScheme)
+		if err != nil {
+			return http.StatusInternalServerError, err
+		}
+		file.NewFileName = fmt.Sprintf("%s%s", id, filepath.Ext(name))
+	}
+	dest := h.UploadDir
+	if h.tools.scanner != nil {
+		dest = h.tools.quarantinePath(h.UploadDir)
+		if err := h.tools.CreateDirIfNotExist(dest); err != nil {
+			return http.StatusInternalServerError, err
+		}
+		err := h.tools.scanFile(r.Context(), h.partPath(u.ID), file)
+		var infected *InfectedFileError
+		if errors.As(err, &infected) {
+			_, _ = moveUnique(h.partPath(u.ID), dest, file.NewFileName)
+			h.remove(u)
+			h.tools.recordUploadRejected(r.Context(), name, u.Length, err)
+			return http.StatusUnprocessableEntity, err
+		}
+		if err != nil {
+			return http.StatusInternalServerError, err
+		}
+		if file.Status == ScanClean {
+			dest = h.UploadDir
+		}
+	}
+	stored, err := moveUnique(h.partPath(u.ID), dest, file.NewFileName)
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	file.NewFileName = stored
+	u.File = file
+	if err := h.save(u); err != nil {
+		return http.StatusInternalServerError, err
+	}
+	h.tools.recordUploadAccepted(r.Context(), h.UploadDir, file, u.Type)
+	if h.OnComplete != nil {
+		h.OnComplete(r, file)
+	}
+	return 0, nil
+}
+
+func (h *TusHandler) fileName(u *tusUpload) string {
+	metadata, _ := parseTusMetadata(u.Metadata)
+	return metadata["filename"]
+}
+
+func (h *TusHandler) load(id string) (*tusUpload, int, error) {
+	if strings.Trim(id, randomStringSource) != "" {
+		return nil, http.StatusNotFound, errors.New("Upload not found")
+	}
+	data, err := os.ReadFile(h.infoPath(id))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, http.StatusNotFound, errors.New("Upload not found")
+		}
+		return nil, http.StatusInternalServerError, err
+	}
+	var u tusUpload
+	if err = json.Unmarshal(data, &u); err != nil {
+		return nil, http.StatusInternalServerError, err
+	}
+	if !u.Expires.IsZero() && time.Now().After(u.Expires) {
+		h.remove(&u)
+		return nil, http.StatusGone, errors.New("Upload has expired")
+	}
+	return &u, 0, nil
+}
+
+func (h *TusHandler) save(u *tusUpload) error {
+	data, err := json.Marshal(u)
+	if err != nil {
+		return err
+	}
+	tmp := h.infoPath(u.ID) + ".tmp"
+	if err = os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, h.infoPath(u.ID))
+}
+
+func (h *TusHandler) remove(u *tusUpload) {
+	_ = os.Remove(h.partPath(u.ID))
+	_ = os.Remove(h.infoPath(u.ID))
+	h.locks.Delete(u.ID)
+	if u.File == nil {
+		h.release(u)
+	}
+}
+
+func (h *TusHandler) release(u *tusUpload) {
+	if h.tools.quota != nil {
+		_ = h.tools.quota.Release(u.Owner, h.UploadDir, u.Length)
+	}
+}
+
+func (h *TusHandler) offset(u *tusUpload) (int64, error) {
+	if u.File != nil {
+		return u.Length, nil
+	}
+	info, err := os.Stat(h.partPath(u.ID))
+	if err != nil {
+		return 0, err
+	}
+	return info.Size(), nil
+}
+
+func (h *TusHandler) lock(id string) (func(), bool) {
+	v, _ := h.locks.LoadOrStore(id, &sync.Mutex{})
+	mu := v.(*sync.Mutex)
+	if !mu.TryLock() {
+		return nil, false
+	}
+	return mu.Unlock, true
+}
+
+func (h *TusHandler) setExpires(w http.ResponseWriter, u *tusUpload) {
+	if !u.Expires.IsZero() && u.File == nil {
+		w.Header().Set("Upload-Expires", u.Expires.Format(http.TimeFormat))
+	}
+}
+
+// error sends err to the client. Internal errors are logged and replaced by a
+// generic message, as they may hold paths of the upload directory.
+func (h *TusHandler) error(w http.ResponseWriter, r *http.Request, err error, status int) {
+	if status >= http.StatusInternalServerError {
+		h.tools.log(r.Context(), slog.LevelError, "tus upload failed",
+			slog.String("method", r.Method),
+			slog.String("path", r.URL.Path),
+			slog.String("error", err.Error()))
+		err = errors.New(http.StatusText(status))
+	}
+	if r.Method == http.MethodHead {
+		w.WriteHeader(status)
+		return
+	}
+	_ = h.tools.ErrorJSON(w, err, status)
+}
+
+func (h *TusHandler) partPath(id string) string {
+	return filepath.Join(h.UploadDir, ".tus-"+id+".part")
+}
+
+func (h *TusHandler) infoPath(id string) string {
+	return filepath.Join(h.UploadDir, ".tus-"+id+".info")
+}
+
+func parseTusMetadata(s string) (map[string]string, error) {
+	metadata := make(map[string]string)
+	if s == "" {
+		return metadata, nil
+	}
+	for _, pair := range strings.Split(s, ",") {
+		key, value, _ := strings.Cut(strings.TrimSpace(pair), " ")
+		if key == "" {
+			return nil, errors.New("Upload-Metadata header is invalid")
+		}
+		decoded, err := base64.StdEncoding.DecodeString(value)
+		if err != nil {
+			return nil, errors.New("Upload-Metadata header is invalid")
+		}
+		metadata[key] = string(decoded)
+	}
+	return metadata, nil
+}
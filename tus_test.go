@@ -0,0 +1,234 @@
+package toolkit
+
+import (
+	"bytes"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func tusRequest(method, target string, body []byte, headers map[string]string) *http.Request {
+	req := httptest.NewRequest(method, target, bytes.NewReader(body))
+	req.Header.Set("Tus-Resumable", tusVersion)
+	for key, value := range headers {
+		req.Header.Set(key, value)
+	}
+	return req
+}
+
+func TestTusHandler_Upload(t *testing.T) {
+	img, err := os.ReadFile("./testdata/img.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var testTools Tools
+	testTools.allowedTypes = []string{"image/png"}
+	dir := t.TempDir()
+	handler := testTools.NewTusHandler(dir, "/files/")
+	var completed *UploadedFile
+	completions := 0
+	handler.OnComplete = func(r *http.Request, file *UploadedFile) {
+		completed = file
+		completions++
+	}
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, tusRequest("POST", "/files/", nil, map[string]string{
+		"Upload-Length":   strconv.Itoa(len(img)),
+		"Upload-Metadata": "filename " + base64.StdEncoding.EncodeToString([]byte("img.png")),
+	}))
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("Wrong status code on creation, expected 201 but got %d", rr.Code)
+	}
+	location := rr.Header().Get("Location")
+	if rr.Header().Get("Upload-Expires") == "" {
+		t.Error("Upload-Expires header is missing")
+	}
+
+	half := len(img) / 2
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, tusRequest("PATCH", location, img[:half], map[string]string{
+		"Content-Type":  "application/offset+octet-stream",
+		"Upload-Offset": "0",
+	}))
+	if rr.Code != http.StatusNoContent {
+		t.Fatalf("Wrong status code on first patch, expected 204 but got %d", rr.Code)
+	}
+
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, tusRequest("HEAD", location, nil, nil))
+	if rr.Header().Get("Upload-Offset") != strconv.Itoa(half) {
+		t.Errorf("Wrong offset, expected %d but got %s", half, rr.Header().Get("Upload-Offset"))
+	}
+
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, tusRequest("PATCH", location, img[half:], map[string]string{
+		"Content-Type":  "application/offset+octet-stream",
+		"Upload-Offset": "0",
+	}))
+	if rr.Code != http.StatusConflict {
+		t.Errorf("Wrong status code for mismatched offset, expected 409 but got %d", rr.Code)
+	}
+
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, tusRequest("PATCH", location, img[half:], map[string]string{
+		"Content-Type":  "application/offset+octet-stream",
+		"Upload-Offset": strconv.Itoa(half),
+	}))
+	if rr.Code != http.StatusNoContent {
+		t.Fatalf("Wrong status code on last patch, expected 204 but got %d", rr.Code)
+	}
+	if completed == nil {
+		t.Fatal("OnComplete was not called")
+	}
+	if completed.OriginalFileName != "img.png" || completed.FileSize != int64(len(img)) {
+		t.Errorf("Unexpected uploaded file: %+v", completed)
+	}
+	data, err := os.ReadFile(filepath.Join(dir, completed.NewFileName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, img) {
+		t.Error("Uploaded file does not match the original")
+	}
+
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, tusRequest("PATCH", location, nil, map[string]string{
+		"Content-Type":  "application/offset+octet-stream",
+		"Upload-Offset": strconv.Itoa(len(img)),
+	}))
+	if rr.Code != http.StatusNoContent || rr.Header().Get("Upload-Offset") != strconv.Itoa(len(img)) {
+		t.Errorf("Wrong response to a repeated last patch, expected 204 but got %d %s", rr.Code, rr.Body.String())
+	}
+	if completions != 1 {
+		t.Errorf("Expected the upload to complete once, got %d", completions)
+	}
+
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, tusRequest("DELETE", location, nil, nil))
+	if rr.Code != http.StatusNoContent {
+		t.Errorf("Wrong status code on termination, expected 204 but got %d", rr.Code)
+	}
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, tusRequest("HEAD", location, nil, nil))
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("Wrong status code after termination, expected 404 but got %d", rr.Code)
+	}
+}
+
+var tusErrorTests = []struct {
+	name         string
+	allowedTypes []string
+	maxFileSize  int64
+	expiration   time.Duration
+	version      string
+	body         []byte
+	status       int
+}{
+	{
+		name:    "wrong version",
+		version: "0.2.2",
+		body:    []byte("hello"),
+		status:  http.StatusPreconditionFailed,
+	},
+	{
+		name:        "too large",
+		version:     tusVersion,
+		maxFileSize: 4,
+		body:        []byte("hello"),
+		status:      http.StatusRequestEntityTooLarge,
+	},
+	{
+		name:         "type not allowed",
+		allowedTypes: []string{"image/png"},
+		version:      tusVersion,
+		body:         []byte("hello"),
+		status:       http.StatusUnsupportedMediaType,
+	},
+	{
+		name:         "empty file type not allowed",
+		allowedTypes: []string{"image/png"},
+		version:      tusVersion,
+		body:         []byte{},
+		status:       http.StatusUnsupportedMediaType,
+	},
+	{
+		name:       "expired",
+		version:    tusVersion,
+		expiration: time.Nanosecond,
+		body:       []byte("hello"),
+		status:     http.StatusGone,
+	},
+}
+
+func TestTusHandler_Errors(t *testing.T) {
+	for _, e := range tusErrorTests {
+		var testTools Tools
+		testTools.allowedTypes = e.allowedTypes
+		testTools.maxFileSize = e.maxFileSize
+		handler := testTools.NewTusHandler(t.TempDir(), "/files")
+		if e.expiration > 0 {
+			handler.Expiration = e.expiration
+		}
+
+		req := tusRequest("POST", "/files", nil, map[string]string{"Upload-Length": strconv.Itoa(len(e.body))})
+		req.Header.Set("Tus-Resumable", e.version)
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		if rr.Code != http.StatusCreated {
+			if rr.Code != e.status {
+				t.Errorf("%s - Wrong status code, expected %d but got %d", e.name, e.status, rr.Code)
+			}
+			if entries, _ := os.ReadDir(handler.UploadDir); len(entries) != 0 {
+				t.Errorf("%s - Expected no upload to be kept, found %d files", e.name, len(entries))
+			}
+			continue
+		}
+
+		time.Sleep(time.Millisecond)
+		rr2 := httptest.NewRecorder()
+		handler.ServeHTTP(rr2, tusRequest("PATCH", rr.Header().Get("Location"), e.body, map[string]string{
+			"Content-Type":  "application/offset+octet-stream",
+			"Upload-Offset": "0",
+		}))
+		if rr2.Code != e.status {
+			t.Errorf("%s - Wrong status code, expected %d but got %d", e.name, e.status, rr2.Code)
+		}
+		entries, _ := os.ReadDir(handler.UploadDir)
+		if len(entries) != 0 {
+			t.Errorf("%s - Expected the upload to be removed, found %d files", e.name, len(entries))
+		}
+	}
+}
+
+func TestTusHandler_InternalError(t *testing.T) {
+	var testTools Tools
+	dir := t.TempDir()
+	handler := testTools.NewTusHandler(dir, "/files")
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, tusRequest("POST", "/files", nil, map[string]string{"Upload-Length": "5"}))
+	location := rr.Header().Get("Location")
+	id := filepath.Base(location)
+	if err := os.Remove(handler.partPath(id)); err != nil {
+		t.Fatal(err)
+	}
+
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, tusRequest("PATCH", location, []byte("hello"), map[string]string{
+		"Content-Type":  "application/offset+octet-stream",
+		"Upload-Offset": "0",
+	}))
+	if rr.Code != http.StatusInternalServerError {
+		t.Errorf("Wrong status code, expected 500 but got %d", rr.Code)
+	}
+	if strings.Contains(rr.Body.String(), dir) {
+		t.Errorf("Internal error leaks the upload directory: %s", rr.Body.String())
+	}
+}